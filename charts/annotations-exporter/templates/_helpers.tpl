@@ -44,11 +44,66 @@ app.kubernetes.io/name: {{ include "exporter.name" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
 
+{{/*
+Resources watched by the exporter from cmdArgs or config file mappings as JSON list
+*/}}
+{{- define "exporter.resources" -}}
+{{- $default := index .Values.cmdArgs "kube.resources" }}
+{{- if .Values.config }}
+{{- $default = .Values.config.resources | default $default }}
+{{- $resources := list }}
+{{- range $mapping := .Values.config.mappings }}
+{{- $resources = concat $resources ( $mapping.resources | default $default ) }}
+{{- end }}
+{{- $resources | default $default | uniq | toJson }}
+{{- else }}
+{{- $default | toJson }}
+{{- end }}
+{{- end }}
+
+{{/*
+Namespaces watched by the exporter from cmdArgs or config file mappings as JSON list
+*/}}
+{{- define "exporter.namespaces" -}}
+{{- $default := index .Values.cmdArgs "kube.namespaces" }}
+{{- if .Values.config }}
+{{- $default = .Values.config.namespaces | default $default }}
+{{- $namespaces := list }}
+{{- range $mapping := .Values.config.mappings }}
+{{- $namespaces = concat $namespaces ( $mapping.namespaces | default $default ) }}
+{{- end }}
+{{- $namespaces = $namespaces | default $default | uniq }}
+{{- if has "" $namespaces }}
+{{- $namespaces = list "" }}
+{{- end }}
+{{- $namespaces | toJson }}
+{{- else }}
+{{- $default | toJson }}
+{{- end }}
+{{- end }}
+
+{{/*
+Check that namespaces are selected by labels, so exporter has to watch namespaces
+*/}}
+{{- define "exporter.namespaceSelector" -}}
+{{- $selector := index .Values.cmdArgs "kube.namespace-selector" | default "" }}
+{{- if .Values.config }}
+{{- $selector = .Values.config.namespace_selector | default "" }}
+{{- range $mapping := .Values.config.mappings }}
+{{- $selector = printf "%s%s" $selector ( $mapping.namespace_selector | default "" ) }}
+{{- end }}
+{{- end }}
+{{- ternary "true" "" ( ne $selector "" ) }}
+{{- end }}
+
 {{- define "parse.resource.string" }}
   {{- $arg := . }}
 	{{- $splitString := "/" }}
 	{{- $resourceSplitted := split $splitString $arg }}
-	{{- if eq ( len $resourceSplitted ) 2 }}
+	{{- if eq ( len $resourceSplitted ) 1 }}
+		{{- /* Categories and all namespaced resources can't be resolved without api discovery, so they are granted by rbac.extraRules */}}
+		{{- dict "selection" $arg | toJson }}
+	{{- else if eq ( len $resourceSplitted ) 2 }}
 		{{- dict "resource" $resourceSplitted._0 "api" $resourceSplitted._1 | toJson }}
 	{{- else if eq ( len $resourceSplitted ) 3 }}
 	  {{- dict "resource" $resourceSplitted._0 "version" $resourceSplitted._1 "api" $resourceSplitted._2 | toJson }}
@@ -57,10 +112,22 @@ app.kubernetes.io/instance: {{ .Release.Name }}
   {{- end }}
 {{- end }}
 
+{{/*
+RBAC rules match exact names or all names, so resource and api patterns are replaced with "*"
+*/}}
+{{- define "rbac.name" }}
+  {{- if or ( contains "*" . ) ( contains "?" . ) ( hasPrefix "~" . ) }}
+    {{- "*" }}
+  {{- else }}
+    {{- . }}
+  {{- end }}
+{{- end }}
+
 {{- define "format.prom.label" }}
 	{{- $arg := . }}
-	{{- $result := replace "/" "_" $arg }}
-	{{- $result = replace "." "_" $result }}
-	{{- $result = replace "-" "_" $result }}
-	{{- lower $result }}
+	{{- $result := regexReplaceAll "[^a-z0-9_]" ( lower $arg ) "_" }}
+	{{- if regexMatch "^[0-9]" $result }}
+		{{- $result = printf "_%s" $result }}
+	{{- end }}
+	{{- $result }}
 {{- end }}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/config"
+	"github.com/alex123012/annotations-exporter/pkg/kube"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// mappingFlags describe the single mapping that can be declared from the command line.
+var mappingFlags = []string{
+	"kube.annotations",
+	"kube.labels",
+	"kube.reference-annotations",
+	"kube.reference-labels",
+	"kube.only-labels-and-annotations",
+}
+
+// loadConfig merges the config file with command line flags. Explicitly set flags take precedence over top-level values
+// and values of every mapping of the config file, and the config file takes precedence over flags default values.
+//
+// The mapping built from flags is used if the config file declares no mappings or any of mapping flags is set explicitly.
+// In the latter case explicitly set mapping flags override fields of the config file mapping with the same name,
+// or the mapping is added to the config file mappings.
+func loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	cfg := &config.Config{}
+	if configPath != "" {
+		var err error
+		if cfg, err = config.Load(configPath); err != nil {
+			return nil, err
+		}
+	}
+
+	flags := cmd.Flags()
+	if flags.Changed("kube.namespaces") || len(cfg.Namespaces) == 0 {
+		cfg.Namespaces = namespaces
+	}
+	if flags.Changed("kube.namespace-selector") || cfg.NamespaceSelector == "" {
+		cfg.NamespaceSelector = namespaceSelector
+	}
+	if flags.Changed("kube.exclude-namespaces") || len(cfg.ExcludeNamespaces) == 0 {
+		cfg.ExcludeNamespaces = excludeNamespaces
+	}
+	if flags.Changed("kube.resources") || len(cfg.Resources) == 0 {
+		cfg.Resources = config.NewResources(resources)
+	}
+	if flags.Changed("kube.exclude-resources") || len(cfg.ExcludeResources) == 0 {
+		cfg.ExcludeResources = excludeResources
+	}
+	for i := range cfg.Resources {
+		if flags.Changed("kube.label-selector") || cfg.Resources[i].LabelSelector == "" {
+			cfg.Resources[i].LabelSelector = labelSelector
+		}
+		if flags.Changed("kube.field-selector") || cfg.Resources[i].FieldSelector == "" {
+			cfg.Resources[i].FieldSelector = fieldSelector
+		}
+	}
+	if flags.Changed("kube.discovery-interval") || cfg.DiscoveryInterval == 0 {
+		cfg.DiscoveryInterval = discoveryInterval
+	}
+	if flags.Changed("kube.reconcile-interval") || cfg.ReconcileInterval == 0 {
+		cfg.ReconcileInterval = reconcileInterval
+	}
+	if flags.Changed("persistence.backend") || cfg.Persistence.Backend == "" {
+		cfg.Persistence.Backend = persistenceBackend
+	}
+	if flags.Changed("persistence.path") || cfg.Persistence.Path == "" {
+		cfg.Persistence.Path = persistencePath
+	}
+	if flags.Changed("persistence.namespace") || cfg.Persistence.Namespace == "" {
+		cfg.Persistence.Namespace = persistenceNamespace
+	}
+	if flags.Changed("persistence.name") || cfg.Persistence.Name == "" {
+		cfg.Persistence.Name = persistenceName
+	}
+	if flags.Changed("persistence.debounce") || cfg.Persistence.Debounce == 0 {
+		cfg.Persistence.Debounce = persistenceDebounce
+	}
+	if flags.Changed("kube.max-revisions") || cfg.MaxRevisions == 0 {
+		cfg.MaxRevisions = maxRevisions
+	}
+	if flags.Changed("limits.max-series") || cfg.MaxSeries == 0 {
+		cfg.MaxSeries = maxSeries
+	}
+	if flags.Changed("limits.max-series-per-metric") || cfg.Limits.MaxSeries == 0 {
+		cfg.Limits.MaxSeries = maxSeriesPerMetric
+	}
+	if flags.Changed("limits.max-values-per-key") || cfg.Limits.MaxValuesPerKey == 0 {
+		cfg.Limits.MaxValuesPerKey = maxValuesPerKey
+	}
+	if flags.Changed("limits.overflow") || cfg.Limits.Overflow == "" {
+		cfg.Limits.Overflow = overflow
+	}
+
+	mappingFlagsChanged := false
+	for _, name := range mappingFlags {
+		mappingFlagsChanged = mappingFlagsChanged || flags.Changed(name)
+	}
+	if len(cfg.Mappings) == 0 || mappingFlagsChanged {
+		cfg.Mappings = mergeFlagsMapping(flags, cfg.Mappings, kube.ResourceMapping(labels, annotations, 0,
+			onlyLabelsAndAnnotations, referenceLabels, referenceAnnotations))
+	}
+	for i := range cfg.Mappings {
+		applyFlags(flags, &cfg.Mappings[i])
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// mergeFlagsMapping sets explicitly set mapping flags to the config file mapping with the same name, other fields
+// of the mapping are kept. The flags mapping is added if there is no such mapping.
+func mergeFlagsMapping(flags *pflag.FlagSet, mappings []config.Mapping, flagsMapping collector.Mapping) []config.Mapping {
+	for i := range mappings {
+		if mappings[i].Name != flagsMapping.Name {
+			continue
+		}
+		mapping := &mappings[i].Mapping
+		if flags.Changed("kube.labels") {
+			mapping.KubeLabels = flagsMapping.KubeLabels
+		}
+		if flags.Changed("kube.annotations") {
+			mapping.KubeAnnotations = flagsMapping.KubeAnnotations
+		}
+		if flags.Changed("kube.reference-labels") {
+			mapping.ReferenceLabels = flagsMapping.ReferenceLabels
+		}
+		if flags.Changed("kube.reference-annotations") {
+			mapping.ReferenceAnnotations = flagsMapping.ReferenceAnnotations
+		}
+		if flags.Changed("kube.only-labels-and-annotations") {
+			mapping.OnlyLabelsAndAnnotations = flagsMapping.OnlyLabelsAndAnnotations
+			mapping.KubeResourceMeta = flagsMapping.KubeResourceMeta
+		}
+		return mappings
+	}
+	return append(mappings, config.Mapping{Mapping: flagsMapping})
+}
+
+// applyFlags makes explicitly set flags take precedence over values of the mapping. Overridden resources and
+// namespaces are cleared, so the mapping inherits top-level values set from the flags.
+func applyFlags(flags *pflag.FlagSet, mapping *config.Mapping) {
+	if flags.Changed("kube.namespaces") {
+		mapping.Namespaces = nil
+	}
+	if flags.Changed("kube.namespace-selector") {
+		mapping.NamespaceSelector = ""
+	}
+	if flags.Changed("kube.exclude-namespaces") {
+		mapping.ExcludeNamespaces = nil
+	}
+	if flags.Changed("kube.resources") {
+		mapping.Resources = nil
+	}
+	if flags.Changed("kube.exclude-resources") {
+		mapping.ExcludeResources = nil
+	}
+	for i := range mapping.Resources {
+		if flags.Changed("kube.label-selector") {
+			mapping.Resources[i].LabelSelector = labelSelector
+		}
+		if flags.Changed("kube.field-selector") {
+			mapping.Resources[i].FieldSelector = fieldSelector
+		}
+	}
+	if flags.Changed("kube.max-revisions") {
+		mapping.MaxRevisions = maxRevisions
+	}
+	if flags.Changed("limits.max-series-per-metric") {
+		mapping.Limits.MaxSeries = maxSeriesPerMetric
+	}
+	if flags.Changed("limits.max-values-per-key") {
+		mapping.Limits.MaxValuesPerKey = maxValuesPerKey
+	}
+	if flags.Changed("limits.overflow") {
+		mapping.Limits.Overflow = overflow
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alex123012/annotations-exporter/pkg/config"
+	"github.com/alex123012/annotations-exporter/pkg/kube"
+	"github.com/spf13/pflag"
+)
+
+const testConfig = `namespaces: [default]
+resources:
+  - deployments/apps
+max_revisions: 2
+mappings:
+  - name: kube_annotations_exporter
+    help: Releases of deployments
+    kube_labels: [app]
+    fields:
+      - name: replicas
+        path: spec.replicas
+  - name: ingresses_info
+    namespaces: [ingress]
+    namespace_selector: team=web
+    resources:
+      - resource: ingresses/v1/networking.k8s.io
+        label_selector: app=web
+    max_revisions: 5
+    kube_annotations: [kubernetes.io/ingress.class]
+`
+
+// testLoadConfig loads the config file with command line arguments. Package variables bound to flags are restored
+// after the test.
+func testLoadConfig(t *testing.T, data string, args ...string) *config.Config {
+	t.Helper()
+	cmd := newCommand()
+	defaults := make(map[string]interface{})
+	cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+		if value, ok := f.Value.(pflag.SliceValue); ok {
+			defaults[f.Name] = value.GetSlice()
+		} else {
+			defaults[f.Name] = f.Value.String()
+		}
+	})
+	t.Cleanup(func() {
+		cmd.PersistentFlags().VisitAll(func(f *pflag.Flag) {
+			if value, ok := f.Value.(pflag.SliceValue); ok {
+				_ = value.Replace(defaults[f.Name].([]string))
+			} else {
+				_ = f.Value.Set(defaults[f.Name].(string))
+			}
+		})
+	})
+
+	if data != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append(args, "--config="+path)
+	}
+	if err := cmd.ParseFlags(args); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   string
+		args   []string
+		verify func(t *testing.T, cfg *config.Config)
+	}{
+		{
+			name: "flags without the config file",
+			args: []string{"--kube.labels=app", "--kube.max-revisions=4"},
+			verify: func(t *testing.T, cfg *config.Config) {
+				if len(cfg.Mappings) != 1 || cfg.Mappings[0].Name != kube.ExporterMetricName {
+					t.Fatalf("expected the flags mapping, got %v", cfg.Mappings)
+				}
+				if mapping := cfg.Mappings[0]; mapping.MaxRevisions != 4 || !reflect.DeepEqual(mapping.KubeLabels, []string{"app"}) {
+					t.Errorf("expected flags values, got %d revisions and %v labels", mapping.MaxRevisions, mapping.KubeLabels)
+				}
+			},
+		},
+		{
+			name: "file values take precedence over flags defaults",
+			data: testConfig,
+			verify: func(t *testing.T, cfg *config.Config) {
+				deployments, ingresses := cfg.Mappings[0], cfg.Mappings[1]
+				if !reflect.DeepEqual(cfg.Namespaces, []string{"default"}) || !reflect.DeepEqual(deployments.Namespaces, []string{"default"}) {
+					t.Errorf("expected namespaces from the file, got %v and %v", cfg.Namespaces, deployments.Namespaces)
+				}
+				if deployments.MaxRevisions != 2 || ingresses.MaxRevisions != 5 {
+					t.Errorf("expected max revisions 2 and 5, got %d and %d", deployments.MaxRevisions, ingresses.MaxRevisions)
+				}
+				if !reflect.DeepEqual(ingresses.Namespaces, []string{"ingress"}) || ingresses.NamespaceSelector != "team=web" {
+					t.Errorf("expected mapping namespaces from the file, got %v and %q", ingresses.Namespaces, ingresses.NamespaceSelector)
+				}
+			},
+		},
+		{
+			name: "flags defaults fill values missing in the file",
+			data: testConfig,
+			verify: func(t *testing.T, cfg *config.Config) {
+				if cfg.ReconcileInterval != reconcileInterval || cfg.Persistence.Debounce != persistenceDebounce {
+					t.Errorf("expected default intervals, got %v and %v", cfg.ReconcileInterval, cfg.Persistence.Debounce)
+				}
+			},
+		},
+		{
+			name: "explicitly set flags take precedence over mappings",
+			data: testConfig,
+			args: []string{"--kube.namespaces=prod", "--kube.namespace-selector=", "--kube.max-revisions=3",
+				"--kube.label-selector=tier=frontend", "--limits.overflow=hash"},
+			verify: func(t *testing.T, cfg *config.Config) {
+				for _, mapping := range cfg.Mappings {
+					if !reflect.DeepEqual(mapping.Namespaces, []string{"prod"}) || mapping.NamespaceSelector != "" {
+						t.Errorf("mapping %s: expected namespaces from flags, got %v and %q", mapping.Name,
+							mapping.Namespaces, mapping.NamespaceSelector)
+					}
+					if mapping.MaxRevisions != 3 || mapping.Limits.Overflow != "hash" {
+						t.Errorf("mapping %s: expected revisions and limits from flags, got %d and %q", mapping.Name,
+							mapping.MaxRevisions, mapping.Limits.Overflow)
+					}
+					for _, resource := range mapping.Resources {
+						if resource.LabelSelector != "tier=frontend" {
+							t.Errorf("mapping %s: expected the label selector from flags, got %q", mapping.Name, resource.LabelSelector)
+						}
+					}
+				}
+			},
+		},
+		{
+			name: "mapping flags override only their fields",
+			data: testConfig,
+			args: []string{"--kube.annotations=version"},
+			verify: func(t *testing.T, cfg *config.Config) {
+				mapping := cfg.Mappings[0]
+				if !reflect.DeepEqual(mapping.KubeAnnotations, []string{"version"}) {
+					t.Errorf("expected annotations from flags, got %v", mapping.KubeAnnotations)
+				}
+				if !reflect.DeepEqual(mapping.KubeLabels, []string{"app"}) || mapping.Help != "Releases of deployments" ||
+					len(mapping.Fields) != 1 {
+					t.Errorf("expected other fields from the file, got labels %v, help %q and fields %v",
+						mapping.KubeLabels, mapping.Help, mapping.Fields)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.verify(t, testLoadConfig(t, tt.data, tt.args...))
+		})
+	}
+}
@@ -2,34 +2,67 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/alex123012/annotations-exporter/pkg/apiresources"
 	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/config"
 	"github.com/alex123012/annotations-exporter/pkg/kube"
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	"github.com/alex123012/annotations-exporter/pkg/selfmetrics"
 	"github.com/alex123012/annotations-exporter/pkg/server"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
 	v1 "k8s.io/api/core/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 )
 
+// version and commit are set on build with -ldflags "-X main.version=... -X main.commit=...".
 var (
-	exporterAddress string   = ":8000"
-	namespaces      []string = []string{v1.NamespaceAll}
-	annotations     []string
-	labels          []string
-	resources       []string = []string{
+	version = "0.1.0"
+	commit  string
+)
+
+var (
+	exporterAddress   string   = ":8000"
+	namespaces        []string = []string{v1.NamespaceAll}
+	excludeNamespaces []string
+	excludeResources  []string
+	annotations       []string
+	labels            []string
+	resources         []string = []string{
 		"deployments/apps",
 		"ingresses/v1/networking.k8s.io",
 		"statefulsets/apps",
 		"daemonsets/apps",
 	}
-	maxRevisions int = 3
-	logLevel     string
-	kubeconfig   string
+	maxRevisions      int = 3
+	discoveryInterval time.Duration
+	reconcileInterval time.Duration = 10 * time.Minute
+	logLevel          string
+	kubeconfig        string
+	configPath        string
+
+	labelSelector     string
+	fieldSelector     string
+	namespaceSelector string
+
+	persistenceBackend   string
+	persistencePath      string
+	persistenceNamespace string
+	persistenceName      string
+	persistenceDebounce  time.Duration = 10 * time.Second
+
+	maxSeries          int
+	maxSeriesPerMetric int
+	maxValuesPerKey    int
+	overflow           string = collector.OverflowDrop
 
 	onlyLabelsAndAnnotations bool
 	referenceAnnotations     []string
@@ -37,13 +70,27 @@ var (
 )
 
 func main() {
+	cmd := newCommand()
 
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := cmd.ExecuteContext(ctx); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newCommand returns the exporter command with flags bound to the package variables.
+func newCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:     "annotations-exporter",
 		Short:   "Export annotations and labels from k8s resources to prometheus metrics",
-		Version: "0.1.0",
+		Version: version,
 		Run: func(cmd *cobra.Command, args []string) {
-			if err := Run(cmd.Context()); err != nil {
+			cfg, err := loadConfig(cmd)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if err := Run(cmd.Context(), cfg); err != nil {
 				log.Fatal(err)
 			}
 		},
@@ -54,62 +101,155 @@ func main() {
 	flags.StringVar(&logLevel, "server.log-level", logLevel, "Log level")
 	flags.StringSliceVar(&annotations, "kube.annotations", annotations, "Annotations names to use in prometheus metric labels")
 	flags.StringSliceVar(&labels, "kube.labels", labels, "Labels names to use in prometheus metric labels")
-	flags.StringSliceVar(&resources, "kube.resources", resources, "Resources (<resource>/<version>/<api> or <resource>/<api>) to export labels and annotations")
+	flags.StringSliceVar(&resources, "kube.resources", resources, "Resources (<resource>/<version>/<api> or <resource>/<api>, resource and api may be globs or regular expressions prefixed with '~'), discovery categories or '*' for all namespaced resources to export labels and annotations")
+	flags.StringSliceVar(&excludeResources, "kube.exclude-resources", excludeResources, "Resources to skip from resources selected by patterns or categories, in the same format as resources (optional)")
+	flags.StringVar(&labelSelector, "kube.label-selector", labelSelector, "Label selector to filter watched resources objects (optional)")
+	flags.StringVar(&fieldSelector, "kube.field-selector", fieldSelector, "Field selector to filter watched resources objects (optional)")
 	flags.StringSliceVar(&namespaces, "kube.namespaces", namespaces, "Specifies the namespace that the exporter will monitor resources in (default 'all namespaces')")
+	flags.StringVar(&namespaceSelector, "kube.namespace-selector", namespaceSelector, "Label selector of namespaces to watch, namespaces are selected when created or relabelled (optional)")
+	flags.StringSliceVar(&excludeNamespaces, "kube.exclude-namespaces", excludeNamespaces, "Namespaces names, globs or regular expressions prefixed with '~' to skip objects from (optional)")
 	flags.IntVar(&maxRevisions, "kube.max-revisions", maxRevisions, "Max revisions of resource labels to store")
+	flags.DurationVar(&discoveryInterval, "kube.discovery-interval", discoveryInterval, "Interval to refresh api discovery and watch resources installed after startup, e.g. CRDs (default 0, missing resources are an error)")
+	flags.DurationVar(&reconcileInterval, "kube.reconcile-interval", reconcileInterval, "Interval to remove stored objects that are not found in informer caches, e.g. if their delete events were missed (0 disables the reconcile)")
+	flags.IntVar(&maxSeries, "limits.max-series", maxSeries, "Max number of series of all metrics, new series over the limit are dropped (default 0, no limit)")
+	flags.IntVar(&maxSeriesPerMetric, "limits.max-series-per-metric", maxSeriesPerMetric, "Max number of series of every metric, new series over the limit are dropped (default 0, no limit)")
+	flags.IntVar(&maxValuesPerKey, "limits.max-values-per-key", maxValuesPerKey, "Max number of distinct values of every label, annotation and field key of every metric (default 0, no limit)")
+	flags.StringVar(&overflow, "limits.overflow", overflow, "What to do with new values of keys over the limit: drop the sample, replace the value with '__overflow__' or hash it into one of 16 '__overflow__<n>' values")
 	flags.StringVar(&kubeconfig, "kube.config", kubeconfig, "Path to kubeconfig (optional)")
+	flags.StringVar(&configPath, "config", configPath, "Path to YAML or JSON file with metric mappings (optional, explicitly set flags take precedence)")
+	flags.StringVar(&persistenceBackend, "persistence.backend", persistenceBackend, "Backend to persist revisions history across restarts: file or configmap (optional)")
+	flags.StringVar(&persistencePath, "persistence.path", persistencePath, "Snapshot file path for file persistence backend")
+	flags.StringVar(&persistenceNamespace, "persistence.namespace", persistenceNamespace, "ConfigMap namespace for configmap persistence backend")
+	flags.StringVar(&persistenceName, "persistence.name", persistenceName, "ConfigMap name for configmap persistence backend")
+	flags.DurationVar(&persistenceDebounce, "persistence.debounce", persistenceDebounce, "Minimal interval between snapshot saves")
 	flags.StringSliceVar(&referenceAnnotations, "kube.reference-annotations", referenceAnnotations, "Annotations names to use in prometheus metric labels and for count revisions (reference names)")
 	flags.StringSliceVar(&referenceLabels, "kube.reference-labels", referenceLabels, "Labels names to use in prometheus metric labels and for count revisions (reference names)")
 	flags.BoolVar(&onlyLabelsAndAnnotations, "kube.only-labels-and-annotations", onlyLabelsAndAnnotations, "Export only labels and annotations defined by flags (default false)")
-
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
-	defer cancel()
-	if err := cmd.ExecuteContext(ctx); err != nil {
-		log.Fatal(err)
-	}
+	return cmd
 }
 
-func Run(ctx context.Context) error {
-	namespaces, err := validateNamespaces(namespaces)
+func Run(ctx context.Context, cfg *config.Config) error {
+	clusterConfig, err := GenerateNewConfig(kubeconfig)
 	if err != nil {
 		return err
 	}
 
-	clusterConfig, err := GenerateNewConfig(kubeconfig)
+	apiResources, err := apiresources.GetAllApiResources(clusterConfig)
 	if err != nil {
 		return err
 	}
-	apiResources, err := apiresources.CompareWithApiResources(clusterConfig, resources)
-	if err != nil {
-		return err
+
+	bindings := make([]kube.Binding, len(cfg.Mappings))
+	requests := make(map[string]kube.ResourceRequests, len(cfg.Mappings))
+	for i, mapping := range cfg.Mappings {
+		namespaces, err := validateNamespaces(mapping.Namespaces)
+		if err != nil {
+			return err
+		}
+
+		metricRequests := kube.ResourceRequests{Exclude: mapping.ExcludeResources}
+		for _, resource := range mapping.Resources {
+			metricRequests.Resources = append(metricRequests.Resources, kube.ResourceRequest{
+				Resource:      resource.Resource,
+				LabelSelector: resource.LabelSelector,
+				FieldSelector: resource.FieldSelector,
+			})
+		}
+		requests[mapping.Name] = metricRequests
+		resources, pending, err := kube.ResolveResources(apiResources, metricRequests)
+		if err != nil {
+			return fmt.Errorf("mapping %s: %w", mapping.Name, err)
+		}
+		if len(pending) > 0 {
+			if cfg.DiscoveryInterval == 0 {
+				return fmt.Errorf("mapping %s: no such resource in kubernetes api: %v", mapping.Name, pending[0])
+			}
+			log.Printf("Resources %v for metric %s are not served by kubernetes api, waiting for them", pending, mapping.Name)
+		}
+		kube.SetPendingResources(mapping.Name, metricRequests, pending)
+		for _, resource := range resources {
+			log.Printf("Starting watching for resource %s for metric %s", resource.String(), mapping.Name)
+		}
+
+		bindings[i] = kube.Binding{
+			MetricName: mapping.Name,
+			Resources:  resources,
+			Namespaces: namespaces,
+			Kinds:      mapping.Kinds,
+		}
+		if keys, ok := mapping.ObjectKeys(); ok {
+			bindings[i].ObjectKeys = keys
+		} else {
+			bindings[i].WholeObject = true
+		}
+		if bindings[i].ExcludeNamespaces, err = pattern.CompileList(mapping.ExcludeNamespaces); err != nil {
+			return fmt.Errorf("mapping %s: %w", mapping.Name, err)
+		}
+		if mapping.NamespaceSelector != "" {
+			if bindings[i].NamespaceSelector, err = k8slabels.Parse(mapping.NamespaceSelector); err != nil {
+				return fmt.Errorf("mapping %s: %w", mapping.Name, err)
+			}
+		}
 	}
-	for _, res := range apiResources {
-		log.Printf("Starting watching for resource: %s", res.String())
+
+	mappings := make([]collector.Mapping, len(cfg.Mappings))
+	for i, mapping := range cfg.Mappings {
+		mappings[i], err = kube.ExpandMappingKeys(ctx, clusterConfig, kube.CompleteMapping(mapping.Mapping), bindings[i])
+		if err != nil {
+			return err
+		}
 	}
 
-	metricVault := collector.NewVault()
-	if err := metricVault.RegisterMappings([]collector.Mapping{
-		kube.ResourceMapping(labels, annotations, maxRevisions,
-			onlyLabelsAndAnnotations, referenceLabels, referenceAnnotations),
-	}); err != nil {
-		log.Fatal(err)
+	selfMetrics := selfmetrics.NewRegistry(version, commit)
+	if err := registerSelfMetrics(selfMetrics); err != nil {
+		return err
 	}
 
-	errorCh := make(chan error)
+	metricVault := collector.NewVault(selfMetrics)
+	metricVault.SetMaxSeries(cfg.MaxSeries)
+	if err := metricVault.RegisterMappings(mappings); err != nil {
+		return fmt.Errorf("register mappings: %w", err)
+	}
+
+	// persisted is closed after the last snapshot is saved on shutdown.
+	var persisted <-chan struct{}
+	if cfg.Persistence.Backend != "" {
+		persister, err := newPersister(clusterConfig, cfg.Persistence, metricVault)
+		if err != nil {
+			return err
+		}
+		if err := persister.Restore(ctx); err != nil {
+			return fmt.Errorf("restore revisions history: %w", err)
+		}
+		persisted = persister.Start(ctx)
+	}
 
-	informerController, err := kube.NewResourcesInformer(clusterConfig, namespaces, apiResources, metricVault)
+	informerController, err := kube.NewResourcesInformer(clusterConfig, bindings, metricVault, selfMetrics)
 	if err != nil {
-		log.Fatalf("kubernetes informer: %v", err)
+		return fmt.Errorf("kubernetes informer: %w", err)
 	}
 
-	go server.StartMetricsServer(ctx, exporterAddress, errorCh)
+	// Informers report their errors to resource health, so only the metrics server stops the exporter.
+	errorCh := make(chan error)
+
+	metrics := prometheus.Gatherers{metricVault.Registry(), selfmetrics.NewRuntimeRegistry()}
+	go server.StartMetricsServer(ctx, exporterAddress, metrics, selfMetrics, informerController, errorCh)
+
+	go informerController.Run(ctx)
 
-	go informerController.Run(ctx, errorCh)
+	if cfg.DiscoveryInterval > 0 {
+		go informerController.RefreshResources(ctx, clusterConfig, cfg.DiscoveryInterval, requests)
+	}
+
+	if cfg.ReconcileInterval > 0 {
+		go informerController.RunReconcile(ctx, cfg.ReconcileInterval)
+	}
 
 	for {
 		select {
 		case s := <-ctx.Done():
 			log.Printf("signal received: %v, exiting...", s)
+			waitPersisted(persisted)
 			return nil
 		case err := <-errorCh:
 			log.Fatalf("error received: %v", err)
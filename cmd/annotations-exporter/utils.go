@@ -6,7 +6,13 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/config"
+	"github.com/alex123012/annotations-exporter/pkg/kube"
+	"github.com/alex123012/annotations-exporter/pkg/persistence"
+	"github.com/prometheus/client_golang/prometheus"
 	v1 "k8s.io/api/core/v1"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
 	"k8s.io/client-go/rest"
@@ -107,3 +113,47 @@ func validateNamespaces(namespaces []string) ([]string, error) {
 // 		}
 // 	}
 // }
+
+// registerSelfMetrics registers self metrics shared by components of the exporter.
+func registerSelfMetrics(registerer prometheus.Registerer) error {
+	for _, register := range []func(prometheus.Registerer) error{
+		collector.RegisterMetrics, kube.RegisterMetrics, persistence.RegisterMetrics,
+	} {
+		if err := register(registerer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newPersister(clusterConfig *rest.Config, cfg config.Persistence, vault *collector.MetricsVault) (*persistence.Persister, error) {
+	var backend persistence.Backend
+	switch cfg.Backend {
+	case config.PersistenceFile:
+		backend = &persistence.FileBackend{Path: cfg.Path}
+		log.Printf("persisting revisions history to file %q", cfg.Path)
+	case config.PersistenceConfigMap:
+		configMapBackend, err := persistence.NewConfigMapBackend(clusterConfig, cfg.Namespace, cfg.Name)
+		if err != nil {
+			return nil, err
+		}
+		backend = configMapBackend
+		log.Printf("persisting revisions history to configmap %s/%s", cfg.Namespace, cfg.Name)
+	default:
+		return nil, fmt.Errorf("unknown persistence backend %q", cfg.Backend)
+	}
+	return persistence.NewPersister(backend, vault, cfg.Debounce), nil
+}
+
+// waitPersisted waits until the last snapshot is saved on shutdown. Saves are limited by the timeout, so it doesn't
+// block the exit if the backend hangs.
+func waitPersisted(persisted <-chan struct{}) {
+	if persisted == nil {
+		return
+	}
+	select {
+	case <-persisted:
+	case <-time.After(persistence.SaveTimeout + 5*time.Second):
+		log.Println("snapshot: the last save is not finished, exiting")
+	}
+}
@@ -3,6 +3,7 @@ package apiresources
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
 
 	"golang.org/x/sync/errgroup"
@@ -15,9 +16,39 @@ import (
 type resourceToMap struct {
 	Key   schema.GroupVersionResource
 	Value schema.GroupVersionResource
+	// Resource is set only for the key with the version to list every resource once.
+	Resource *APIResource
 }
 
-func GetAllApiResources(config *rest.Config) (map[schema.GroupVersionResource]schema.GroupVersionResource, error) {
+// APIResource is the resource served by the kubernetes api with its discovery data.
+type APIResource struct {
+	schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+	Categories []string
+	Verbs      []string
+}
+
+// Watchable checks that the resource supports list and watch verbs and is not a subresource.
+func (r *APIResource) Watchable() bool {
+	if strings.Contains(r.Resource, "/") {
+		return false
+	}
+	var list, watch bool
+	for _, verb := range r.Verbs {
+		list = list || verb == "list"
+		watch = watch || verb == "watch"
+	}
+	return list && watch
+}
+
+// APIResources are preferred versions of resources served by the kubernetes api.
+type APIResources struct {
+	resources map[schema.GroupVersionResource]schema.GroupVersionResource
+	list      []*APIResource
+}
+
+func GetAllApiResources(config *rest.Config) (*APIResources, error) {
 
 	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
 	if err != nil {
@@ -26,8 +57,17 @@ func GetAllApiResources(config *rest.Config) (map[schema.GroupVersionResource]sc
 
 	APIResourceListSlice, err := discoveryClient.ServerPreferredResources()
 	if err != nil {
-		return nil, err
+		// Groups of unavailable aggregated APIs are skipped, resources of other groups are still returned.
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, err
+		}
+		log.Printf("partial api discovery: %v", err)
 	}
+	return newAPIResources(APIResourceListSlice)
+}
+
+// newAPIResources indexes resources of discovery lists.
+func newAPIResources(APIResourceListSlice []*v1.APIResourceList) (*APIResources, error) {
 	errGroup, _ := errgroup.WithContext(context.Background())
 	resourceChan := make(chan resourceToMap)
 	for _, singleAPIResourceList := range APIResourceListSlice {
@@ -42,7 +82,7 @@ func GetAllApiResources(config *rest.Config) (map[schema.GroupVersionResource]sc
 		defer close(resourceChan)
 		errorCh <- errGroup.Wait()
 	}()
-	resourceKindMap := make(map[schema.GroupVersionResource]schema.GroupVersionResource)
+	apiResources := &APIResources{resources: make(map[schema.GroupVersionResource]schema.GroupVersionResource)}
 	for {
 		select {
 		case err := <-errorCh:
@@ -50,9 +90,12 @@ func GetAllApiResources(config *rest.Config) (map[schema.GroupVersionResource]sc
 				close(errorCh)
 				return nil, err
 			}
-			return resourceKindMap, nil
+			return apiResources, nil
 		case resource := <-resourceChan:
-			resourceKindMap[resource.Key] = resource.Value
+			apiResources.resources[resource.Key] = resource.Value
+			if resource.Resource != nil {
+				apiResources.list = append(apiResources.list, resource.Resource)
+			}
 		}
 	}
 }
@@ -75,36 +118,23 @@ func getNamesFromResources(apiResource *v1.APIResourceList, resourceChan chan<-
 				Group:    apiVersion.Group,
 			},
 			Value: groupVersionResource,
+			Resource: &APIResource{
+				GroupVersionResource: groupVersionResource,
+				Kind:                 resource.Kind,
+				Namespaced:           resource.Namespaced,
+				Categories:           resource.Categories,
+				Verbs:                resource.Verbs,
+			},
 		}
 		resourceChan <- resourceKeyValue
 
 		resourceKeyValue.Key.Version = ""
+		resourceKeyValue.Resource = nil
 		resourceChan <- resourceKeyValue
 	}
 	return nil
 }
 
-func CompareWithApiResources(config *rest.Config, flagList []string) ([]schema.GroupVersionResource, error) {
-	apiResorces, err := GetAllApiResources(config)
-	if err != nil {
-		return nil, err
-	}
-	resultList := make([]schema.GroupVersionResource, len(flagList))
-	for i, resource := range flagList {
-		res, err := ParseResourceString(resource)
-		if err != nil {
-			return nil, err
-		}
-		if value, f := apiResorces[*res]; f {
-			resultList[i] = value
-			continue
-		}
-		return nil, fmt.Errorf("no such resource in kubernetes api: %v", res)
-	}
-
-	return resultList, nil
-}
-
 func ParseResourceString(arg string) (*schema.GroupVersionResource, error) {
 	split := "/"
 	resource := strings.Split(arg, split)
@@ -0,0 +1,149 @@
+package apiresources
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// AllNamespaced selects all namespaced resources.
+const AllNamespaced = "*"
+
+// Selector selects resources served by the kubernetes api. The resource string is one of:
+//   - <resource>/<api> or <resource>/<version>/<api> with the exact resource name, e.g. deployments/apps
+//   - the same with glob or regular expression patterns of the resource name and the api, e.g. */apps or */*.example.com
+//   - the discovery category without slashes, e.g. all
+//   - "*" for all namespaced resources
+//
+// Resources selected by patterns and categories are limited to ones that support list and watch verbs.
+type Selector struct {
+	raw      string
+	exact    *schema.GroupVersionResource
+	category string
+	resource *pattern.Pattern
+	version  string
+	group    *pattern.Pattern
+}
+
+// ParseSelector parses the resource string.
+func ParseSelector(arg string) (*Selector, error) {
+	if arg == AllNamespaced {
+		return &Selector{raw: arg}, nil
+	}
+	if arg != "" && !pattern.IsPattern(arg) && !strings.Contains(arg, "/") {
+		return &Selector{raw: arg, category: arg}, nil
+	}
+
+	gvr, err := ParseResourceString(arg)
+	if err != nil {
+		return nil, err
+	}
+	if !pattern.IsPattern(gvr.Resource) && !pattern.IsPattern(gvr.Group) {
+		return &Selector{raw: arg, exact: gvr}, nil
+	}
+
+	resource, err := pattern.Compile(gvr.Resource)
+	if err != nil {
+		return nil, err
+	}
+	group, err := pattern.Compile(gvr.Group)
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{raw: arg, resource: resource, version: gvr.Version, group: group}, nil
+}
+
+func (s *Selector) String() string {
+	return s.raw
+}
+
+// Matches checks that the resource is selected. The exact resource string matches any served version
+// of the resource if the version is omitted.
+func (s *Selector) Matches(resource *APIResource) bool {
+	switch {
+	case s.exact != nil:
+		return s.exact.Resource == resource.Resource && s.exact.Group == resource.Group &&
+			(s.exact.Version == "" || s.exact.Version == resource.Version)
+	case s.category != "":
+		for _, category := range resource.Categories {
+			if category == s.category {
+				return resource.Watchable()
+			}
+		}
+		return false
+	case s.resource == nil:
+		return resource.Namespaced && resource.Watchable()
+	}
+	return s.resource.Match(resource.Resource) && s.group.Match(resource.Group) &&
+		(s.version == "" || s.version == resource.Version) && resource.Watchable()
+}
+
+// Resolve returns resources selected by the resource string sorted by api and name.
+// The exact resource string is resolved as is, so its version doesn't have to be the preferred one.
+func (r *APIResources) Resolve(resource string) ([]schema.GroupVersionResource, error) {
+	selector, err := ParseSelector(resource)
+	if err != nil {
+		return nil, err
+	}
+	if selector.exact != nil {
+		if value, found := r.resources[*selector.exact]; found {
+			return []schema.GroupVersionResource{value}, nil
+		}
+		return nil, nil
+	}
+
+	var result []schema.GroupVersionResource
+	for _, apiResource := range r.list {
+		if selector.Matches(apiResource) {
+			result = append(result, apiResource.GroupVersionResource)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Group != result[j].Group {
+			return result[i].Group < result[j].Group
+		}
+		return result[i].Resource < result[j].Resource
+	})
+	return result, nil
+}
+
+// Exclude removes resources selected by any of exclude resource strings.
+func (r *APIResources) Exclude(resources []schema.GroupVersionResource, exclude []string) ([]schema.GroupVersionResource, error) {
+	excluded := make(map[schema.GroupVersionResource]struct{})
+	for _, resource := range exclude {
+		selector, err := ParseSelector(resource)
+		if err != nil {
+			return nil, err
+		}
+		for _, apiResource := range r.list {
+			if selector.Matches(apiResource) {
+				excluded[apiResource.GroupVersionResource] = struct{}{}
+			}
+		}
+		if selector.exact != nil {
+			if value, found := r.resources[*selector.exact]; found {
+				excluded[value] = struct{}{}
+			}
+		}
+	}
+
+	var result []schema.GroupVersionResource
+	for _, resource := range resources {
+		if _, ok := excluded[resource]; !ok {
+			result = append(result, resource)
+		}
+	}
+	return result, nil
+}
+
+// Kind returns the kind of objects of the served resource, or an empty string if the resource is not served.
+func (r *APIResources) Kind(resource schema.GroupVersionResource) string {
+	for _, apiResource := range r.list {
+		if apiResource.GroupVersionResource == resource {
+			return apiResource.Kind
+		}
+	}
+	return ""
+}
@@ -0,0 +1,229 @@
+package apiresources
+
+import (
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+var watchVerbs = v1.Verbs{"get", "list", "watch"}
+
+// testAPIResources returns resources of the discovery list of the test cluster.
+func testAPIResources(t *testing.T) *APIResources {
+	t.Helper()
+	apiResources, err := newAPIResources([]*v1.APIResourceList{
+		{
+			GroupVersion: "v1",
+			APIResources: []v1.APIResource{
+				{Name: "pods", Kind: "Pod", Namespaced: true, Categories: []string{"all"}, Verbs: watchVerbs},
+				{Name: "secrets", Kind: "Secret", Namespaced: true, Verbs: watchVerbs},
+				{Name: "bindings", Kind: "Binding", Namespaced: true, Verbs: v1.Verbs{"create"}},
+				{Name: "namespaces", Kind: "Namespace", Verbs: watchVerbs},
+			},
+		},
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []v1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Categories: []string{"all"}, Verbs: watchVerbs},
+				{Name: "deployments/scale", Kind: "Scale", Namespaced: true, Verbs: watchVerbs},
+				{Name: "statefulsets", Kind: "StatefulSet", Namespaced: true, Categories: []string{"all"}, Verbs: watchVerbs},
+			},
+		},
+		{
+			GroupVersion: "networking.k8s.io/v1",
+			APIResources: []v1.APIResource{
+				{Name: "ingresses", Kind: "Ingress", Namespaced: true, Verbs: watchVerbs},
+				{Name: "ingressclasses", Kind: "IngressClass", Verbs: watchVerbs},
+			},
+		},
+		{
+			GroupVersion: "example.com/v1beta1",
+			APIResources: []v1.APIResource{
+				{Name: "widgets", Kind: "Widget", Namespaced: true, Categories: []string{"all", "example"}, Verbs: v1.Verbs{"list"}},
+				{Name: "gadgets", Kind: "Gadget", Namespaced: true, Categories: []string{"example"}, Verbs: watchVerbs},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apiResources
+}
+
+var (
+	pods           = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+	secrets        = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+	bindings       = schema.GroupVersionResource{Version: "v1", Resource: "bindings"}
+	deployments    = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+	statefulsets   = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "statefulsets"}
+	ingresses      = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingresses"}
+	ingressclasses = schema.GroupVersionResource{Group: "networking.k8s.io", Version: "v1", Resource: "ingressclasses"}
+	gadgets        = schema.GroupVersionResource{Group: "example.com", Version: "v1beta1", Resource: "gadgets"}
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource string
+		valid    bool
+	}{
+		{name: "exact resource", resource: "deployments/apps", valid: true},
+		{name: "exact resource with version", resource: "deployments/v1/apps", valid: true},
+		{name: "glob pattern", resource: "*/apps", valid: true},
+		{name: "regular expression", resource: `~^ingress/~^networking\.`, valid: true},
+		{name: "category", resource: "all", valid: true},
+		{name: "all namespaced", resource: AllNamespaced, valid: true},
+		{name: "glob without api", resource: "deploy*"},
+		{name: "invalid regular expression", resource: "~(/apps"},
+		{name: "too many parts", resource: "deployments/v1/apps/extra"},
+		{name: "empty", resource: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selector, err := ParseSelector(tt.resource)
+			if tt.valid && err != nil {
+				t.Errorf("expected valid selector, got %v", err)
+			}
+			if !tt.valid && err == nil {
+				t.Errorf("expected error, got selector %v", selector)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	apiResources := testAPIResources(t)
+	tests := []struct {
+		name     string
+		resource string
+		expected []schema.GroupVersionResource
+	}{
+		{
+			name:     "exact resource",
+			resource: "deployments/apps",
+			expected: []schema.GroupVersionResource{deployments},
+		},
+		{
+			name:     "exact resource with version",
+			resource: "deployments/v1/apps",
+			expected: []schema.GroupVersionResource{deployments},
+		},
+		{
+			name:     "exact resource with not served version",
+			resource: "deployments/v2/apps",
+		},
+		{
+			name:     "exact resource is resolved without verbs check",
+			resource: "bindings/",
+			expected: []schema.GroupVersionResource{bindings},
+		},
+		{
+			name:     "glob skips subresources",
+			resource: "*/apps",
+			expected: []schema.GroupVersionResource{deployments, statefulsets},
+		},
+		{
+			name:     "glob of the api selects cluster resources too",
+			resource: "*/*.k8s.io",
+			expected: []schema.GroupVersionResource{ingressclasses, ingresses},
+		},
+		{
+			name:     "regular expression",
+			resource: `~^(pods|secrets|bindings)$/`,
+			expected: []schema.GroupVersionResource{pods, secrets},
+		},
+		{
+			name:     "pattern with version",
+			resource: "*/v1beta1/example.com",
+			expected: []schema.GroupVersionResource{gadgets},
+		},
+		{
+			name:     "category skips not watchable resources",
+			resource: "all",
+			expected: []schema.GroupVersionResource{pods, deployments, statefulsets},
+		},
+		{
+			name:     "unknown category",
+			resource: "unknown",
+		},
+		{
+			name:     "all namespaced watchable resources",
+			resource: AllNamespaced,
+			expected: []schema.GroupVersionResource{pods, secrets, deployments, statefulsets, gadgets, ingresses},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := apiResources.Resolve(tt.resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestExclude(t *testing.T) {
+	apiResources := testAPIResources(t)
+	tests := []struct {
+		name     string
+		resource string
+		exclude  []string
+		expected []schema.GroupVersionResource
+	}{
+		{
+			name:     "exact resource",
+			resource: AllNamespaced,
+			exclude:  []string{"secrets/"},
+			expected: []schema.GroupVersionResource{pods, deployments, statefulsets, gadgets, ingresses},
+		},
+		{
+			name:     "exclude takes precedence over the exact resource",
+			resource: "deployments/apps",
+			exclude:  []string{"*/apps"},
+		},
+		{
+			name:     "exact resource not selected by patterns",
+			resource: "bindings/v1/",
+			exclude:  []string{"bindings/"},
+		},
+		{
+			name:     "category and pattern",
+			resource: AllNamespaced,
+			exclude:  []string{"all", "~^g/example.com"},
+			expected: []schema.GroupVersionResource{secrets, ingresses},
+		},
+		{
+			name:     "other version",
+			resource: "deployments/apps",
+			exclude:  []string{"deployments/v2/apps"},
+			expected: []schema.GroupVersionResource{deployments},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := apiResources.Resolve(tt.resource)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result, err := apiResources.Exclude(resolved, tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+
+	if _, err := apiResources.Exclude([]schema.GroupVersionResource{pods}, []string{"~(/"}); err == nil {
+		t.Error("expected error of the invalid exclude pattern")
+	}
+}
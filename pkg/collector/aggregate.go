@@ -0,0 +1,180 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// groupAggregate contains tracked values of all members of the reference group. The revision of the group
+// is changed only when all members agree on values.
+type groupAggregate struct {
+	members map[string]groupMember
+	// changes counts changes of agreed tracked values of the group by their types and keys.
+	changes map[[2]string]float64
+}
+
+type groupMember struct {
+	kind   string
+	metric RevisionGaugeMetric
+}
+
+// aggregateDescs describe the metrics of aggregated reference groups.
+type aggregateDescs struct {
+	members   *prometheus.Desc
+	conflicts *prometheus.Desc
+	changes   *prometheus.Desc
+}
+
+func newAggregateDescs(mapping Mapping, labelNames []string) (*aggregateDescs, error) {
+	membersLabels, err := newLabelNamesBuilder(mapping).
+		keys(keyTypeLabel, mapping.ReferenceLabels, mapping.LabelNames.Labels).
+		keys(keyTypeAnnotation, mapping.ReferenceAnnotations, mapping.LabelNames.Annotations).
+		meta("kind").
+		build()
+	if err != nil {
+		return nil, err
+	}
+	changesLabels, err := newLabelNamesBuilder(mapping).
+		keys(keyTypeLabel, mapping.ReferenceLabels, mapping.LabelNames.Labels).
+		keys(keyTypeAnnotation, mapping.ReferenceAnnotations, mapping.LabelNames.Annotations).
+		meta("type", "key").
+		build()
+	if err != nil {
+		return nil, err
+	}
+	return &aggregateDescs{
+		members: prometheus.NewDesc(mapping.Name+"_members",
+			"Number of objects of the kind in the reference group of "+mapping.Name, membersLabels, nil),
+		// The revision label is omitted, conflicting values are not stored as revisions.
+		conflicts: prometheus.NewDesc(mapping.Name+"_conflicts",
+			"Number of objects with the tracked values if objects of the reference group of "+mapping.Name+" disagree on them",
+			labelNames[:len(labelNames)-1], nil),
+		changes: prometheus.NewDesc(mapping.Name+"_changes_total",
+			"Number of changes of agreed tracked values of the reference group of "+mapping.Name+" by the value type and key",
+			changesLabels, nil),
+	}, nil
+}
+
+// aggregate updates tracked values of the group member and stores the revision of the group if members agree.
+// Must be called with the lock held.
+func (c *GaugeCollector) aggregate(sample Sample, group uint64, metric RevisionGaugeMetric) {
+	agg, ok := c.aggregates[group]
+	if !ok {
+		agg = &groupAggregate{members: make(map[string]groupMember), changes: make(map[[2]string]float64)}
+		c.aggregates[group] = agg
+	}
+	kind, _, _, _ := sample.object()
+	agg.members[sample.identity()] = groupMember{
+		kind:   kind,
+		metric: metric,
+	}
+	c.reconcileGroup(group)
+}
+
+// leaveAggregate removes the object from members of the group, the rest of members may agree after that.
+// Must be called with the lock held.
+func (c *GaugeCollector) leaveAggregate(object string, group uint64) {
+	agg, ok := c.aggregates[group]
+	if !ok {
+		return
+	}
+	if _, ok := agg.members[object]; !ok {
+		return
+	}
+	delete(agg.members, object)
+	if len(agg.members) == 0 {
+		delete(c.aggregates, group)
+		return
+	}
+	c.reconcileGroup(group)
+}
+
+// reconcileGroup stores the revision of the group if all members have the same tracked values.
+// Must be called with the lock held.
+func (c *GaugeCollector) reconcileGroup(group uint64) {
+	agg := c.aggregates[group]
+
+	var agreed *groupMember
+	for _, member := range agg.members {
+		member := member
+		if agreed == nil {
+			agreed = &member
+			continue
+		}
+		if !reflect.DeepEqual(agreed.metric.LabelValues, member.metric.LabelValues) {
+			return
+		}
+	}
+
+	// Label values of stored revisions are changed when they are shifted.
+	metric := agreed.metric
+	metric.LabelValues = ConcatMultipleSlices([][]string{metric.LabelValues})
+	metric.Timestamp = time.Now()
+	// Changes are counted for the group, members only agree on them.
+	for _, key := range c.storeRevision(group, metric, c.referenceLen(metric)) {
+		agg.changes[key]++
+	}
+}
+
+// referenceLen returns the number of reference label values in the label values of the group.
+func (c *GaugeCollector) referenceLen(metric RevisionGaugeMetric) int {
+	return len(metric.LabelValues) - len(c.trackedKeys) - 1
+}
+
+// collectAggregates collects member counts and changes of groups and tracked values of groups which members disagree.
+// Must be called with the lock held.
+func (c *GaugeCollector) collectAggregates(ch chan<- prometheus.Metric) {
+	for _, agg := range c.aggregates {
+		var reference []string
+		kinds := make(map[string]int)
+		values := make(map[uint64][]string)
+		counts := make(map[uint64]int)
+		for _, member := range agg.members {
+			labelValues := member.metric.LabelValues[:len(member.metric.LabelValues)-1]
+			if reference == nil {
+				reference = labelValues[:c.referenceLen(member.metric)]
+			}
+			kinds[member.kind]++
+			hash := hashLabels(labelValues)
+			values[hash] = labelValues
+			counts[hash]++
+		}
+
+		for kind, count := range kinds {
+			c.collectGauge(ch, c.aggregateDescs.members, float64(count),
+				ConcatMultipleSlices([][]string{reference, {kind}}))
+		}
+		for key, count := range agg.changes {
+			metric, err := prometheus.NewConstMetric(c.aggregateDescs.changes, prometheus.CounterValue, count,
+				ConcatMultipleSlices([][]string{reference, key[:]})...)
+			if err != nil {
+				log.Printf("prepare counter: %v\n", err)
+				continue
+			}
+			ch <- metric
+		}
+
+		if len(values) < 2 {
+			continue
+		}
+		for hash, labelValues := range values {
+			c.collectGauge(ch, c.aggregateDescs.conflicts, float64(counts[hash]), labelValues)
+		}
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"log"
 	"reflect"
 	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -32,6 +33,10 @@ type ConstMetricCollector interface {
 	Collect(chan<- prometheus.Metric)
 	Store(Sample)
 	Clear(Sample)
+	// Reconcile removes stored objects missing from live samples and returns the number of removed objects.
+	Reconcile(live []Sample) int
+	// Series returns the number of stored series.
+	Series() int
 }
 
 type ResourceGaugeMetric struct {
@@ -40,6 +45,12 @@ type ResourceGaugeMetric struct {
 type RevisionGaugeMetric struct {
 	RevisionValue float64
 	LabelValues   []string
+	// Value is exported instead of the revision number if the mapping sets the value from the resource.
+	Value float64
+	// Timestamp is the time the revision was first observed.
+	Timestamp time.Time
+	// Version is the object generation or resource version the revision was first observed with.
+	Version string
 }
 
 type GaugeCollector struct {
@@ -48,31 +59,120 @@ type GaugeCollector struct {
 	collection map[uint64]*ResourceGaugeMetric
 	desc       *prometheus.Desc
 	mapping    Mapping
+	// timestampDesc describes the companion metric with revision timestamps.
+	timestampDesc *prometheus.Desc
+	// trackedKeys are types and keys of tracked label values after the reference label values.
+	trackedKeys [][2]string
+	// labelNames are names of stored label values.
+	labelNames []string
+	// groups tracks reference groups of objects, the collection is keyed by groups.
+	groups *groupIndex
+	// restored contains keys of objects restored from the snapshot and not stored since then.
+	restored map[uint64]struct{}
+	// aggregates contain values of members of reference groups if the mapping aggregates groups.
+	aggregates     map[uint64]*groupAggregate
+	aggregateDescs *aggregateDescs
+	limiter        *limiter
+	// valueKeys are keys of reference and tracked values in the order of label values, valuePositions are
+	// positions of their values in label values.
+	valueKeys      []string
+	valuePositions map[string]int
+	// onChange is called when the revision history is changed.
+	onChange    func()
+	fields      []*fieldPath
+	valueFrom   *valueSource
+	transformer *transformer
 }
 
-func NewConstGaugeCollector(mapping Mapping) *GaugeCollector {
-
-	resultPrometheusLabels := ConcatMultipleSlices(
-		[][]string{
-			formatPromethuesLabelSlice(mapping.KubeResourceMeta, ApplicationPrefix),
-
-			formatPromethuesLabelSlice(mapping.ReferenceLabels, ApplicationPrefix+"label_"),
-			formatPromethuesLabelSlice(mapping.ReferenceAnnotations, ApplicationPrefix+"annotation_"),
+func NewConstGaugeCollector(mapping Mapping) (*GaugeCollector, error) {
+	fields, err := compileFields(mapping.Fields)
+	if err != nil {
+		return nil, err
+	}
+	valueFrom, err := newValueSource(mapping.Name, mapping.ValueFrom)
+	if err != nil {
+		return nil, err
+	}
+	transformer, err := newTransformer(mapping.Transforms)
+	if err != nil {
+		return nil, err
+	}
 
-			formatPromethuesLabelSlice(mapping.KubeLabels, ApplicationPrefix+"label_"),
-			formatPromethuesLabelSlice(mapping.KubeAnnotations, ApplicationPrefix+"annotation_"),
-			{ApplicationPrefix + "revision"},
-		})
+	labelNames := newLabelNamesBuilder(mapping).
+		meta(mapping.KubeResourceMeta...).
+		keys(keyTypeLabel, mapping.ReferenceLabels, mapping.LabelNames.Labels).
+		keys(keyTypeAnnotation, mapping.ReferenceAnnotations, mapping.LabelNames.Annotations).
+		keys(keyTypeLabel, mapping.KubeLabels, mapping.LabelNames.Labels).
+		keys(keyTypeAnnotation, mapping.KubeAnnotations, mapping.LabelNames.Annotations).
+		keys(keyTypeField, fieldsNames(mapping.Fields), mapping.LabelNames.Fields).
+		meta("revision")
+	revisionLabels := len(labelNames.names)
+	if mapping.RevisionVersion != "" {
+		labelNames = labelNames.meta(mapping.RevisionVersion)
+	}
+	resultPrometheusLabels, err := labelNames.build()
+	if err != nil {
+		return nil, err
+	}
 
-	desc := prometheus.NewDesc(mapping.Name, mapping.Help, resultPrometheusLabels, nil)
-	return &GaugeCollector{mapping: mapping, collection: make(map[uint64]*ResourceGaugeMetric), desc: desc}
+	c := &GaugeCollector{mapping: mapping, collection: make(map[uint64]*ResourceGaugeMetric),
+		fields: fields, valueFrom: valueFrom, transformer: transformer,
+		labelNames: resultPrometheusLabels[:revisionLabels], groups: newGroupIndex(), restored: make(map[uint64]struct{}), onChange: func() {}}
+	c.limiter = newLimiter(mapping.Name, mapping.Limits)
+	for _, key := range mapping.KubeLabels {
+		c.trackedKeys = append(c.trackedKeys, [2]string{keyTypeLabel, key})
+	}
+	for _, key := range mapping.KubeAnnotations {
+		c.trackedKeys = append(c.trackedKeys, [2]string{keyTypeAnnotation, key})
+	}
+	for _, name := range fieldsNames(mapping.Fields) {
+		c.trackedKeys = append(c.trackedKeys, [2]string{keyTypeField, name})
+	}
+	for _, key := range mapping.ReferenceLabels {
+		c.valueKeys = append(c.valueKeys, keyTypeLabel+" "+key)
+	}
+	for _, key := range mapping.ReferenceAnnotations {
+		c.valueKeys = append(c.valueKeys, keyTypeAnnotation+" "+key)
+	}
+	for _, key := range c.trackedKeys {
+		c.valueKeys = append(c.valueKeys, key[0]+" "+key[1])
+	}
+	c.valuePositions = make(map[string]int, len(c.valueKeys))
+	for i, key := range c.valueKeys {
+		c.valuePositions[key] = revisionLabels - 1 - len(c.valueKeys) + i
+	}
+	if mapping.Aggregate {
+		if c.aggregateDescs, err = newAggregateDescs(mapping, c.labelNames); err != nil {
+			return nil, err
+		}
+		c.aggregates = make(map[uint64]*groupAggregate)
+	}
+	switch mapping.RevisionTimestamps {
+	case RevisionTimestampsMetric:
+		// The version is exported only with the revision timestamp.
+		c.desc = prometheus.NewDesc(mapping.Name, mapping.Help, resultPrometheusLabels[:revisionLabels], nil)
+		c.timestampDesc = prometheus.NewDesc(mapping.Name+"_revision_timestamp_seconds",
+			"Time when the revision of "+mapping.Name+" was first observed", resultPrometheusLabels, nil)
+	default:
+		c.desc = prometheus.NewDesc(mapping.Name, mapping.Help, resultPrometheusLabels, nil)
+	}
+	return c, nil
 }
 
 func (c *GaugeCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.desc
+	if c.timestampDesc != nil {
+		ch <- c.timestampDesc
+	}
+	if c.aggregateDescs != nil {
+		ch <- c.aggregateDescs.members
+		ch <- c.aggregateDescs.conflicts
+		ch <- c.aggregateDescs.changes
+	}
 }
 
 func (c *GaugeCollector) Collect(ch chan<- prometheus.Metric) {
+	defer observeCollect(c.mapping.Name, time.Now())
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -81,27 +181,86 @@ func (c *GaugeCollector) Collect(ch chan<- prometheus.Metric) {
 			if metric.LabelValues == nil {
 				continue
 			}
-			metric, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, metric.RevisionValue, metric.LabelValues...)
-			if err != nil {
-				log.Printf("prepare gauge: %v\n", err)
-				continue
+			labelValues := metric.LabelValues
+			if c.mapping.RevisionVersion != "" {
+				labelValues = ConcatMultipleSlices([][]string{labelValues, {metric.Version}})
+			}
+			timestamp := float64(metric.Timestamp.UnixNano()) / float64(time.Second)
+
+			value := metric.RevisionValue
+			switch {
+			case c.valueFrom != nil:
+				value = metric.Value
+			case c.mapping.RevisionTimestamps == RevisionTimestampsValue:
+				value = timestamp
 			}
-			ch <- metric
+
+			if c.timestampDesc != nil {
+				c.collectGauge(ch, c.timestampDesc, timestamp, labelValues)
+				labelValues = metric.LabelValues
+			}
+			c.collectGauge(ch, c.desc, value, labelValues)
 		}
 	}
+	if c.aggregateDescs != nil {
+		c.collectAggregates(ch)
+	}
+}
+
+func (c *GaugeCollector) Series() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.limiter.series
+}
+
+func (c *GaugeCollector) collectGauge(ch chan<- prometheus.Metric, desc *prometheus.Desc, value float64, labelValues []string) {
+	metric, err := prometheus.NewConstMetric(desc, prometheus.GaugeValue, value, labelValues...)
+	if err != nil {
+		log.Printf("prepare gauge: %v\n", err)
+		return
+	}
+	ch <- metric
 }
 
 func (c *GaugeCollector) Store(sample Sample) {
-	kubeReferenceForHash := ConcatMultipleSlices(
+	sample = c.transformer.apply(sample)
+
+	reference := ConcatMultipleSlices(
 		[][]string{
 			compareLabelsSliceWithMap(c.mapping.ReferenceLabels, sample.ResourceLabels),
 			compareLabelsSliceWithMap(c.mapping.ReferenceAnnotations, sample.ResourceAnnotations),
 		})
+	tracked := ConcatMultipleSlices(
+		[][]string{
+			compareLabelsSliceWithMap(c.mapping.KubeLabels, sample.ResourceLabels),
+			compareLabelsSliceWithMap(c.mapping.KubeAnnotations, sample.ResourceAnnotations),
+			fieldsValues(c.fields, c.transformer, sample.Object),
+		})
+
+	var value float64
+	if c.valueFrom != nil {
+		var ok bool
+		if value, ok = c.valueFrom.value(sample); !ok {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			c.removeObject(sample.identity())
+			return
+		}
+	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Values over the limit are replaced before hashing, so they don't create new groups.
+	if !c.limitValues(reference, c.valueKeys[:len(reference)]) || !c.limitValues(tracked, c.valueKeys[len(reference):]) {
+		return
+	}
+
+	kubeReferenceForHash := reference
 	if !c.mapping.OnlyLabelsAndAnnotations {
 		kubeReferenceForHash = ConcatMultipleSlices([][]string{
 			sample.ResourceMeta,
-			kubeReferenceForHash,
+			reference,
 		})
 	}
 
@@ -113,32 +272,178 @@ func (c *GaugeCollector) Store(sample Sample) {
 		LabelValues: ConcatMultipleSlices(
 			[][]string{
 				kubeReferenceForHash,
-				compareLabelsSliceWithMap(c.mapping.KubeLabels, sample.ResourceLabels),
-				compareLabelsSliceWithMap(c.mapping.KubeAnnotations, sample.ResourceAnnotations),
+				tracked,
 				{fmt.Sprint(lastRevision)},
 			}),
+		Value:     value,
+		Timestamp: time.Now(),
+		Version:   sample.version(c.mapping.RevisionVersion),
 	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	delete(c.restored, labelsHash)
+	// The object is moved from the previous group if its reference values are changed.
+	previous, moved, empty := c.groups.assign(sample.identity(), labelsHash)
+	if empty {
+		c.deleteGroup(previous)
+	}
+	if c.mapping.Aggregate {
+		if moved {
+			c.leaveAggregate(sample.identity(), previous)
+		}
+		c.aggregate(sample, labelsHash, newMetric)
+		return
+	}
+	c.countChanges(sample, c.storeRevision(labelsHash, newMetric, len(kubeReferenceForHash)))
+}
+
+// storeRevision stores label values as the new revision of the group if they are changed. It returns types and keys
+// of tracked values changed since the last revision. Must be called with the lock held.
+func (c *GaugeCollector) storeRevision(labelsHash uint64, newMetric RevisionGaugeMetric, referenceLen int) [][2]string {
+	const lastRevision = 0
+	var changed [][2]string
 	storedResourceMetrics, ok := c.collection[labelsHash]
 	if !ok {
+		if !c.limiter.reserveSeries(1) {
+			c.limiter.dropSeries("reference values")
+			return nil
+		}
 		storedResourceMetrics = &ResourceGaugeMetric{
 			RevisionMetrics: make([]RevisionGaugeMetric, c.mapping.MaxRevisions),
 		}
 		storedResourceMetrics.RevisionMetrics[lastRevision] = newMetric
+		c.trackValues(storedResourceMetrics.RevisionMetrics, 1)
 	} else {
 		if reflect.DeepEqual(newMetric.LabelValues, storedResourceMetrics.RevisionMetrics[lastRevision].LabelValues) {
-			return
+			if storedResourceMetrics.RevisionMetrics[lastRevision].Value != newMetric.Value {
+				storedResourceMetrics.RevisionMetrics[lastRevision].Value = newMetric.Value
+				c.onChange()
+			}
+			return nil
 		}
+		changed = c.changedKeys(referenceLen, storedResourceMetrics.RevisionMetrics[lastRevision].LabelValues, newMetric.LabelValues)
+		before := countSeries(storedResourceMetrics.RevisionMetrics)
+		c.trackValues(storedResourceMetrics.RevisionMetrics, -1)
 		storedResourceMetrics.RevisionMetrics = shiftMetricsSlice(storedResourceMetrics.RevisionMetrics, c.mapping.MaxRevisions)
 		storedResourceMetrics.RevisionMetrics[0] = newMetric
+		after := countSeries(storedResourceMetrics.RevisionMetrics)
+		// The history doesn't grow over the series limit, the oldest revision is dropped instead.
+		if after > before && !c.limiter.reserveSeries(after-before) {
+			for i := len(storedResourceMetrics.RevisionMetrics) - 1; i > 0; i-- {
+				if storedResourceMetrics.RevisionMetrics[i].LabelValues != nil {
+					storedResourceMetrics.RevisionMetrics[i] = RevisionGaugeMetric{}
+					after--
+					break
+				}
+			}
+		}
+		if after < before {
+			c.limiter.addSeries(after - before)
+		}
+		c.trackValues(storedResourceMetrics.RevisionMetrics, 1)
 	}
 	c.collection[labelsHash] = storedResourceMetrics
+	c.onChange()
+	return changed
+}
+
+// deleteGroup deletes series of the group. Must be called with the lock held.
+func (c *GaugeCollector) deleteGroup(group uint64) {
+	if s, ok := c.collection[group]; ok {
+		c.limiter.addSeries(-countSeries(s.RevisionMetrics))
+		c.trackValues(s.RevisionMetrics, -1)
+		delete(c.collection, group)
+	}
+}
+
+// limitValues applies the limit of distinct values to values of keys. It returns false if the sample must be dropped.
+// Must be called with the lock held.
+func (c *GaugeCollector) limitValues(values []string, keys []string) bool {
+	for i, key := range keys {
+		value, ok := c.limiter.value(key, values[i])
+		if !ok {
+			return false
+		}
+		values[i] = value
+	}
+	return true
 }
 
+// trackValues counts values of keys of stored revisions for the limiter. Must be called with the lock held.
+func (c *GaugeCollector) trackValues(metrics []RevisionGaugeMetric, delta int) {
+	for _, metric := range metrics {
+		if metric.LabelValues == nil {
+			continue
+		}
+		for key, position := range c.valuePositions {
+			c.limiter.track(key, metric.LabelValues[position], delta)
+		}
+	}
+}
+
+func countSeries(metrics []RevisionGaugeMetric) int {
+	series := 0
+	for _, metric := range metrics {
+		if metric.LabelValues != nil {
+			series++
+		}
+	}
+	return series
+}
+
+// Clear removes the object from its reference group, series of the group are removed with the last member.
 func (c *GaugeCollector) Clear(sample Sample) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	delete(c.collection, hashLabels(sample.ResourceMeta))
+	c.removeObject(sample.identity())
+	if kind, namespace, name, ok := sample.object(); ok {
+		changes.DeletePartialMatch(prometheus.Labels{"metric": c.mapping.Name, "kind": kind, "namespace": namespace, "name": name})
+	}
+}
+
+func (c *GaugeCollector) Reconcile(live []Sample) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	orphans := c.groups.orphans(identities(live))
+	for _, object := range orphans {
+		c.removeObject(object)
+	}
+	return len(orphans)
+}
+
+// removeObject removes the object from its group and series of the group if it has no members anymore.
+// Must be called with the lock held.
+func (c *GaugeCollector) removeObject(object string) {
+	group, empty := c.groups.remove(object)
+	if empty {
+		c.deleteGroup(group)
+		c.onChange()
+	}
+	if c.mapping.Aggregate {
+		c.leaveAggregate(object, group)
+	}
+}
+
+// changedKeys returns types and keys of tracked values that differ in old and new label values.
+func (c *GaugeCollector) changedKeys(offset int, oldValues, newValues []string) [][2]string {
+	if oldValues == nil {
+		return nil
+	}
+	var changed [][2]string
+	for i, key := range c.trackedKeys {
+		if oldValues[offset+i] != newValues[offset+i] {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// countChanges increments changes of tracked values of the object.
+func (c *GaugeCollector) countChanges(sample Sample, changed [][2]string) {
+	kind, namespace, name, ok := sample.object()
+	if !ok {
+		return
+	}
+	for _, key := range changed {
+		changes.WithLabelValues(c.mapping.Name, kind, namespace, name, key[0], key[1]).Inc()
+	}
 }
@@ -0,0 +1,386 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+var testResourceMeta = []string{"api_version", "kind", "namespace", "name"}
+
+func testSample(uid, name string, labels, annotations map[string]string) Sample {
+	return Sample{
+		ResourceLabels:      labels,
+		ResourceAnnotations: annotations,
+		ResourceMeta:        []string{"v1", "Pod", "default", name},
+		UID:                 uid,
+	}
+}
+
+// step stores the sample or clears it if clear is set.
+type step struct {
+	sample Sample
+	clear  bool
+}
+
+func storeStep(sample Sample) step {
+	return step{sample: sample}
+}
+
+func clearStep(sample Sample) step {
+	return step{sample: sample, clear: true}
+}
+
+// storedSeries returns label values of all stored revisions joined by commas.
+func storedSeries(c *GaugeCollector) []string {
+	var result []string
+	for _, s := range c.collection {
+		for _, metric := range s.RevisionMetrics {
+			if metric.LabelValues != nil {
+				result = append(result, strings.Join(metric.LabelValues, ","))
+			}
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestGaugeCollectorIdentity(t *testing.T) {
+	perObject := Mapping{
+		Name:             "test_per_object",
+		KubeResourceMeta: testResourceMeta,
+		KubeAnnotations:  []string{"version"},
+		MaxRevisions:     2,
+	}
+	referenceKeyed := Mapping{
+		Name:             "test_reference_keyed",
+		KubeResourceMeta: testResourceMeta,
+		ReferenceLabels:  []string{"app"},
+		KubeAnnotations:  []string{"version"},
+		MaxRevisions:     2,
+	}
+	onlyLabelsAndAnnotations := Mapping{
+		Name:                     "test_only_labels_and_annotations",
+		ReferenceLabels:          []string{"app"},
+		KubeAnnotations:          []string{"version"},
+		MaxRevisions:             2,
+		OnlyLabelsAndAnnotations: true,
+	}
+
+	a := func(app, version string) Sample {
+		return testSample("uid-a", "a", map[string]string{"app": app}, map[string]string{"version": version})
+	}
+	b := func(app, version string) Sample {
+		return testSample("uid-b", "b", map[string]string{"app": app}, map[string]string{"version": version})
+	}
+
+	tests := []struct {
+		name     string
+		mapping  Mapping
+		steps    []step
+		expected []string
+	}{
+		{
+			name:     "per object: objects are stored separately",
+			mapping:  perObject,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1"))},
+			expected: []string{"v1,Pod,default,a,1,0", "v1,Pod,default,b,1,0"},
+		},
+		{
+			name:     "per object: changed values are stored as revisions",
+			mapping:  perObject,
+			steps:    []step{storeStep(a("x", "1")), storeStep(a("x", "2")), storeStep(a("x", "2"))},
+			expected: []string{"v1,Pod,default,a,1,1", "v1,Pod,default,a,2,0"},
+		},
+		{
+			name:     "per object: delete removes series of the object only",
+			mapping:  perObject,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1")), storeStep(a("x", "2")), clearStep(a("x", "2"))},
+			expected: []string{"v1,Pod,default,b,1,0"},
+		},
+		{
+			name:     "reference keyed: series are keyed by the object and reference values",
+			mapping:  referenceKeyed,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1"))},
+			expected: []string{"v1,Pod,default,a,x,1,0", "v1,Pod,default,b,x,1,0"},
+		},
+		{
+			name:     "reference keyed: series move with changed reference values",
+			mapping:  referenceKeyed,
+			steps:    []step{storeStep(a("x", "1")), storeStep(a("y", "1"))},
+			expected: []string{"v1,Pod,default,a,y,1,0"},
+		},
+		{
+			name:     "reference keyed: delete removes series of current reference values",
+			mapping:  referenceKeyed,
+			steps:    []step{storeStep(a("x", "1")), storeStep(a("y", "1")), clearStep(a("y", "1"))},
+			expected: nil,
+		},
+		{
+			name:    "reference keyed: tombstone with stale reference values removes the object",
+			mapping: referenceKeyed,
+			// The last known state of the tombstone can be older than the stored one.
+			steps:    []step{storeStep(a("x", "1")), storeStep(a("y", "2")), clearStep(a("x", "1"))},
+			expected: nil,
+		},
+		{
+			name:     "only labels and annotations: objects share the reference group",
+			mapping:  onlyLabelsAndAnnotations,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1"))},
+			expected: []string{"x,1,0"},
+		},
+		{
+			name:     "only labels and annotations: series are kept until the last member is deleted",
+			mapping:  onlyLabelsAndAnnotations,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1")), clearStep(a("x", "1"))},
+			expected: []string{"x,1,0"},
+		},
+		{
+			name:     "only labels and annotations: series are removed with the last member",
+			mapping:  onlyLabelsAndAnnotations,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1")), clearStep(a("x", "1")), clearStep(b("x", "1"))},
+			expected: nil,
+		},
+		{
+			name:     "only labels and annotations: the moved object doesn't remove series of the shared group",
+			mapping:  onlyLabelsAndAnnotations,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1")), storeStep(a("y", "1"))},
+			expected: []string{"x,1,0", "y,1,0"},
+		},
+		{
+			name:     "only labels and annotations: series of the left group are removed with its last member",
+			mapping:  onlyLabelsAndAnnotations,
+			steps:    []step{storeStep(a("x", "1")), storeStep(b("x", "1")), storeStep(a("y", "1")), clearStep(b("x", "1"))},
+			expected: []string{"y,1,0"},
+		},
+		{
+			name:    "only labels and annotations: tombstone with stale reference values removes the object",
+			mapping: onlyLabelsAndAnnotations,
+			steps: []step{storeStep(a("x", "1")), storeStep(b("y", "1")), storeStep(a("y", "1")),
+				clearStep(a("x", "1")), clearStep(b("y", "1"))},
+			expected: nil,
+		},
+		{
+			name:    "objects without uid are identified by resource meta",
+			mapping: onlyLabelsAndAnnotations,
+			steps: []step{
+				storeStep(testSample("", "a", map[string]string{"app": "x"}, map[string]string{"version": "1"})),
+				storeStep(testSample("", "b", map[string]string{"app": "x"}, map[string]string{"version": "1"})),
+				clearStep(testSample("", "a", map[string]string{"app": "x"}, nil)),
+			},
+			expected: []string{"x,1,0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConstGaugeCollector(tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.steps {
+				if s.clear {
+					c.Clear(s.sample)
+				} else {
+					c.Store(s.sample)
+				}
+			}
+			if series := storedSeries(c); !reflect.DeepEqual(series, tt.expected) {
+				t.Errorf("expected series %q, got %q", tt.expected, series)
+			}
+			if series := c.Series(); series != len(tt.expected) {
+				t.Errorf("expected %d series to be accounted, got %d", len(tt.expected), series)
+			}
+		})
+	}
+}
+
+func TestGaugeCollectorReconcile(t *testing.T) {
+	c, err := NewConstGaugeCollector(Mapping{
+		Name:                     "test_reconcile",
+		ReferenceLabels:          []string{"app"},
+		MaxRevisions:             1,
+		OnlyLabelsAndAnnotations: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testSample("uid-a", "a", map[string]string{"app": "x"}, nil)
+	b := testSample("uid-b", "b", map[string]string{"app": "y"}, nil)
+	c.Store(a)
+	c.Store(b)
+
+	if removed := c.Reconcile([]Sample{b}); removed != 1 {
+		t.Errorf("expected 1 removed object, got %d", removed)
+	}
+	if series, expected := storedSeries(c), []string{"y,0"}; !reflect.DeepEqual(series, expected) {
+		t.Errorf("expected series %q, got %q", expected, series)
+	}
+}
+
+func TestVaultSelfMetricsRegistration(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	if err := RegisterMetrics(registry); err != nil {
+		t.Fatal(err)
+	}
+	vault := NewVault(registry)
+	for _, name := range []string{"test_first", "test_second"} {
+		if err := vault.RegisterMappings([]Mapping{{Name: name, MaxRevisions: 1}}); err != nil {
+			t.Fatalf("mapping %s: %v", name, err)
+		}
+	}
+	// Vaults with their own registerers don't conflict with each other.
+	if err := NewVault(prometheus.NewRegistry()).RegisterMappings([]Mapping{{Name: "test_first", MaxRevisions: 1}}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGaugeCollectorAggregateChanges(t *testing.T) {
+	c, err := NewConstGaugeCollector(Mapping{
+		Name:                     "test_aggregate_changes",
+		ReferenceLabels:          []string{"app"},
+		KubeAnnotations:          []string{"version"},
+		MaxRevisions:             2,
+		OnlyLabelsAndAnnotations: true,
+		Aggregate:                true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := func(version string) Sample {
+		return testSample("uid-a", "a", map[string]string{"app": "x"}, map[string]string{"version": version})
+	}
+	b := func(version string) Sample {
+		return testSample("uid-b", "b", map[string]string{"app": "x"}, map[string]string{"version": version})
+	}
+	for _, sample := range []Sample{a("1"), b("1"), a("2"), b("2")} {
+		c.Store(sample)
+	}
+
+	agg := c.aggregates[hashLabels([]string{"x"})]
+	if count := agg.changes[[2]string{keyTypeAnnotation, "version"}]; count != 1 {
+		t.Errorf("expected 1 change of the group, got %v", count)
+	}
+	for _, name := range []string{"a", "b"} {
+		if count := testutil.ToFloat64(changes.WithLabelValues(c.mapping.Name, "Pod", "default", name, keyTypeAnnotation, "version")); count != 0 {
+			t.Errorf("expected no changes of the member %s, got %v", name, count)
+		}
+	}
+}
+
+func TestGaugeCollectorChanges(t *testing.T) {
+	c, err := NewConstGaugeCollector(Mapping{
+		Name:             "test_changes",
+		KubeResourceMeta: testResourceMeta,
+		KubeAnnotations:  []string{"version"},
+		MaxRevisions:     2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := func(version string) Sample {
+		return testSample("uid-a", "a", nil, map[string]string{"version": version})
+	}
+	counter := func(name string) float64 {
+		return testutil.ToFloat64(changes.WithLabelValues(c.mapping.Name, "Pod", "default", name, keyTypeAnnotation, "version"))
+	}
+	c.Store(testSample("uid-b", "b", nil, map[string]string{"version": "1"}))
+	for _, version := range []string{"1", "2", "2", "3"} {
+		c.Store(a(version))
+	}
+	if count := counter("a"); count != 2 {
+		t.Errorf("expected 2 changes of the object, got %v", count)
+	}
+	if count := counter("b"); count != 0 {
+		t.Errorf("expected no changes of another object, got %v", count)
+	}
+
+	// The series is created again with the zero value if it was removed.
+	c.Clear(a("3"))
+	if count := counter("a"); count != 0 {
+		t.Errorf("expected changes of the cleared object to be removed, got %v", count)
+	}
+}
+
+func TestGaugeCollectorRevisionTimestamps(t *testing.T) {
+	const metrics = `# HELP test_revision_timestamps Test revisions
+# TYPE test_revision_timestamps gauge
+`
+	tests := []struct {
+		name     string
+		mode     string
+		expected string
+	}{
+		{
+			name: "metric",
+			mode: RevisionTimestampsMetric,
+			expected: metrics + `test_revision_timestamps{annotations_exporter_annotation_version="1",annotations_exporter_api_version="v1",annotations_exporter_kind="Pod",annotations_exporter_name="a",annotations_exporter_namespace="default",annotations_exporter_revision="1"} 1
+test_revision_timestamps{annotations_exporter_annotation_version="2",annotations_exporter_api_version="v1",annotations_exporter_kind="Pod",annotations_exporter_name="a",annotations_exporter_namespace="default",annotations_exporter_revision="0"} 0
+# HELP test_revision_timestamps_revision_timestamp_seconds Time when the revision of test_revision_timestamps was first observed
+# TYPE test_revision_timestamps_revision_timestamp_seconds gauge
+test_revision_timestamps_revision_timestamp_seconds{annotations_exporter_annotation_version="1",annotations_exporter_api_version="v1",annotations_exporter_generation="1",annotations_exporter_kind="Pod",annotations_exporter_name="a",annotations_exporter_namespace="default",annotations_exporter_revision="1"} 1000
+test_revision_timestamps_revision_timestamp_seconds{annotations_exporter_annotation_version="2",annotations_exporter_api_version="v1",annotations_exporter_generation="2",annotations_exporter_kind="Pod",annotations_exporter_name="a",annotations_exporter_namespace="default",annotations_exporter_revision="0"} 2000
+`,
+		},
+		{
+			name: "value",
+			mode: RevisionTimestampsValue,
+			expected: metrics + `test_revision_timestamps{annotations_exporter_annotation_version="1",annotations_exporter_api_version="v1",annotations_exporter_generation="1",annotations_exporter_kind="Pod",annotations_exporter_name="a",annotations_exporter_namespace="default",annotations_exporter_revision="1"} 1000
+test_revision_timestamps{annotations_exporter_annotation_version="2",annotations_exporter_api_version="v1",annotations_exporter_generation="2",annotations_exporter_kind="Pod",annotations_exporter_name="a",annotations_exporter_namespace="default",annotations_exporter_revision="0"} 2000
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConstGaugeCollector(Mapping{
+				Name:               "test_revision_timestamps",
+				Help:               "Test revisions",
+				KubeResourceMeta:   testResourceMeta,
+				KubeAnnotations:    []string{"version"},
+				MaxRevisions:       2,
+				RevisionTimestamps: tt.mode,
+				RevisionVersion:    RevisionVersionGeneration,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Timestamps of new revisions are set to known values, older revisions keep theirs when shifted.
+			setLatestTimestamp := func(seconds int64) {
+				for _, resource := range c.collection {
+					resource.RevisionMetrics[0].Timestamp = time.Unix(seconds, 0)
+				}
+			}
+			sample := testSample("uid-a", "a", nil, map[string]string{"version": "1"})
+			sample.Generation = 1
+			c.Store(sample)
+			setLatestTimestamp(1000)
+			sample = testSample("uid-a", "a", nil, map[string]string{"version": "2"})
+			sample.Generation = 2
+			c.Store(sample)
+			setLatestTimestamp(2000)
+
+			if err := testutil.CollectAndCompare(c, strings.NewReader(tt.expected)); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/client-go/util/jsonpath"
+)
+
+const (
+	// FieldListJoin joins all values found by the path with the separator.
+	FieldListJoin = "join"
+	// FieldListFirst uses the first value found by the path.
+	FieldListFirst = "first"
+	// FieldListAll exports every value found by the path as the separate series, only in ModeKeyValue.
+	FieldListAll = "all"
+
+	defaultFieldSeparator = ","
+)
+
+// Field is the object field extracted by JSONPath and exported as the metric label, e.g. spec.replicas
+// or {.status.conditions[?(@.type=="Ready")].status}.
+type Field struct {
+	Name string `yaml:"name"`
+	Path string `yaml:"path"`
+	// List is one of FieldListJoin (default), FieldListFirst or FieldListAll.
+	List      string `yaml:"list,omitempty"`
+	Separator string `yaml:"separator,omitempty"`
+}
+
+// fieldPath is the parsed field path. JSONPath keeps the state while walking the object, so it is guarded by the mutex.
+type fieldPath struct {
+	Field
+
+	mu   sync.Mutex
+	path *jsonpath.JSONPath
+}
+
+// ParseFieldPath parses the JSONPath template. Plain paths without braces, like spec.replicas, are accepted too.
+func ParseFieldPath(path string) (*jsonpath.JSONPath, error) {
+	p := jsonpath.New("field").AllowMissingKeys(true)
+	if err := p.Parse(fieldTemplate(path)); err != nil {
+		return nil, fmt.Errorf("invalid field path %q: %w", path, err)
+	}
+	return p, nil
+}
+
+func fieldTemplate(path string) string {
+	if strings.HasPrefix(path, "{") {
+		return path
+	}
+	return "{." + strings.TrimPrefix(path, ".") + "}"
+}
+
+// pathKeys returns top-level keys of the object read by the field path. It returns false if keys can't be
+// found out from the template, e.g. for recursive descents or ranges.
+func pathKeys(path string) ([]string, bool) {
+	parser, err := jsonpath.Parse("field", fieldTemplate(path))
+	if err != nil {
+		return nil, false
+	}
+	var keys []string
+	for _, node := range parser.Root.Nodes {
+		switch node := node.(type) {
+		case *jsonpath.TextNode:
+			continue
+		case *jsonpath.ListNode:
+			if len(node.Nodes) == 0 {
+				return nil, false
+			}
+			field, ok := node.Nodes[0].(*jsonpath.FieldNode)
+			if !ok || field.Value == "" || field.Value == "*" {
+				return nil, false
+			}
+			keys = append(keys, field.Value)
+		default:
+			return nil, false
+		}
+	}
+	return keys, true
+}
+
+// ObjectKeys returns sorted top-level keys of objects read by mapping fields and the value. It returns false
+// if keys of any path are unknown, so the whole object is read.
+func (m Mapping) ObjectKeys() ([]string, bool) {
+	paths := make([]string, 0, len(m.Fields)+1)
+	for _, field := range m.Fields {
+		paths = append(paths, field.Path)
+	}
+	if m.ValueFrom != nil && m.ValueFrom.Field != "" {
+		paths = append(paths, m.ValueFrom.Field)
+	}
+
+	unique := make(map[string]struct{})
+	for _, path := range paths {
+		keys, ok := pathKeys(path)
+		if !ok {
+			return nil, false
+		}
+		for _, key := range keys {
+			unique[key] = struct{}{}
+		}
+	}
+	keys := make([]string, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys, true
+}
+
+func compileFields(fields []Field) ([]*fieldPath, error) {
+	result := make([]*fieldPath, len(fields))
+	for i, field := range fields {
+		path, err := ParseFieldPath(field.Path)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = &fieldPath{Field: field, path: path}
+	}
+	return result, nil
+}
+
+// values returns all values found by the path. Strings are used as is, other values are encoded to JSON.
+func (f *fieldPath) values(object map[string]interface{}) []string {
+	if object == nil {
+		return nil
+	}
+
+	f.mu.Lock()
+	results, err := f.path.FindResults(object)
+	f.mu.Unlock()
+	if err != nil {
+		log.Printf("field %s: %v", f.Name, err)
+		return nil
+	}
+
+	var values []string
+	for _, result := range results {
+		for _, value := range result {
+			if !value.IsValid() || !value.CanInterface() || value.Interface() == nil {
+				continue
+			}
+			if s, ok := value.Interface().(string); ok {
+				values = append(values, s)
+				continue
+			}
+			encoded, err := json.Marshal(value.Interface())
+			if err != nil {
+				log.Printf("field %s: %v", f.Name, err)
+				continue
+			}
+			values = append(values, string(encoded))
+		}
+	}
+	return values
+}
+
+// value returns the single value of the field according to the list setting.
+func (f *fieldPath) value(object map[string]interface{}) string {
+	values := f.values(object)
+	if len(values) == 0 {
+		return ""
+	}
+	if f.List == FieldListFirst {
+		return values[0]
+	}
+	separator := f.Separator
+	if separator == "" {
+		separator = defaultFieldSeparator
+	}
+	return strings.Join(values, separator)
+}
+
+func fieldsValues(fields []*fieldPath, t *transformer, object map[string]interface{}) []string {
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		result[i] = t.field(field.Name, field.value(object))
+	}
+	return result
+}
+
+func fieldsNames(fields []Field) []string {
+	result := make([]string, len(fields))
+	for i, field := range fields {
+		result[i] = field.Name
+	}
+	return result
+}
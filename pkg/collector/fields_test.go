@@ -0,0 +1,69 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMappingObjectKeys(t *testing.T) {
+	tests := []struct {
+		name      string
+		paths     []string
+		valueFrom *ValueFrom
+		expected  []string
+		ok        bool
+	}{
+		{name: "no fields", expected: []string{}, ok: true},
+		{name: "plain path", paths: []string{"spec.replicas"}, expected: []string{"spec"}, ok: true},
+		{name: "plain path with leading dot", paths: []string{".spec.replicas"}, expected: []string{"spec"}, ok: true},
+		{name: "braces", paths: []string{"{.status.replicas}"}, expected: []string{"status"}, ok: true},
+		{name: "metadata", paths: []string{"metadata.creationTimestamp"}, expected: []string{"metadata"}, ok: true},
+		{
+			name:     "filter",
+			paths:    []string{`{.status.conditions[?(@.type=="Ready")].status}`},
+			expected: []string{"status"},
+			ok:       true,
+		},
+		{name: "array index", paths: []string{"spec.containers[0].image"}, expected: []string{"spec"}, ok: true},
+		{name: "wildcard below the top level", paths: []string{"{.spec.*}"}, expected: []string{"spec"}, ok: true},
+		{name: "several templates", paths: []string{"{.spec.a}/{.status.b}"}, expected: []string{"spec", "status"}, ok: true},
+		{name: "several fields", paths: []string{"status.b", "spec.a", "spec.c"}, expected: []string{"spec", "status"}, ok: true},
+		{name: "value from field", paths: []string{"spec.a"}, valueFrom: &ValueFrom{Field: "status.b"}, expected: []string{"spec", "status"}, ok: true},
+		{name: "value from annotation", valueFrom: &ValueFrom{Annotation: "a"}, expected: []string{}, ok: true},
+		{name: "recursive descent", paths: []string{"{..image}"}},
+		{name: "recursive descent with other fields", paths: []string{"spec.a", "{..image}"}},
+		{name: "range", paths: []string{"{range .spec.containers[*]}{.image}{end}"}},
+		{name: "top-level wildcard", paths: []string{"{.*}"}},
+		{name: "top-level filter", paths: []string{`{[?(@.kind=="Pod")]}`}},
+		{name: "value from recursive descent", valueFrom: &ValueFrom{Field: "{..replicas}"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := Mapping{ValueFrom: tt.valueFrom}
+			for _, path := range tt.paths {
+				mapping.Fields = append(mapping.Fields, Field{Name: "field", Path: path})
+			}
+			keys, ok := mapping.ObjectKeys()
+			if ok != tt.ok {
+				t.Fatalf("expected ok %v, got %v with keys %q", tt.ok, ok, keys)
+			}
+			if ok && !reflect.DeepEqual(keys, tt.expected) {
+				t.Errorf("expected keys %q, got %q", tt.expected, keys)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+// groupIndex tracks the reference group every object belongs to and members of every group. Series of the group
+// are kept while it has members, so the object can be removed even if its reference values are changed since
+// it was stored, and objects sharing the group don't remove series of each other.
+type groupIndex struct {
+	objects map[string]uint64
+	members map[uint64]map[string]struct{}
+}
+
+func newGroupIndex() *groupIndex {
+	return &groupIndex{
+		objects: make(map[string]uint64),
+		members: make(map[uint64]map[string]struct{}),
+	}
+}
+
+// assign moves the object to the group. It returns the previous group of the object, true if the object is moved
+// from another group and true if the previous group has no members anymore.
+func (i *groupIndex) assign(object string, group uint64) (uint64, bool, bool) {
+	previous, ok := i.objects[object]
+	if ok && previous == group {
+		return 0, false, false
+	}
+
+	i.objects[object] = group
+	if i.members[group] == nil {
+		i.members[group] = make(map[string]struct{})
+	}
+	i.members[group][object] = struct{}{}
+
+	if !ok {
+		return 0, false, false
+	}
+	return previous, true, i.leave(object, previous)
+}
+
+// remove removes the object. It returns the group of the object and true if the group has no members anymore.
+func (i *groupIndex) remove(object string) (uint64, bool) {
+	group, ok := i.objects[object]
+	if !ok {
+		return 0, false
+	}
+	delete(i.objects, object)
+	return group, i.leave(object, group)
+}
+
+// orphans returns objects that are not live.
+func (i *groupIndex) orphans(live map[string]struct{}) []string {
+	var result []string
+	for object := range i.objects {
+		if _, ok := live[object]; !ok {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+// size returns the number of members of the group.
+func (i *groupIndex) size(group uint64) int {
+	return len(i.members[group])
+}
+
+func (i *groupIndex) leave(object string, group uint64) bool {
+	delete(i.members[group], object)
+	if len(i.members[group]) > 0 {
+		return false
+	}
+	delete(i.members, group)
+	return true
+}
+
+// identities returns identities of samples.
+func identities(samples []Sample) map[string]struct{} {
+	result := make(map[string]struct{}, len(samples))
+	for _, sample := range samples {
+		result[sample.identity()] = struct{}{}
+	}
+	return result
+}
@@ -0,0 +1,319 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	keyTypeLabel      = "label"
+	keyTypeAnnotation = "annotation"
+	keyTypeField      = "field"
+)
+
+// KeyValueCollector exports one series per resource label or annotation with the key and the value as metric labels,
+// so keys don't have to be known in advance. Mapping labels and annotations are used as patterns of keys to export.
+type KeyValueCollector struct {
+	mu sync.RWMutex
+
+	collection map[uint64][][]string
+	desc       *prometheus.Desc
+	mapping    Mapping
+
+	labelKeys      pattern.List
+	annotationKeys pattern.List
+	excludeKeys    pattern.List
+	fields         []*fieldPath
+	transformer    *transformer
+	groups         *groupIndex
+	limiter        *limiter
+	// referencePositions are positions of reference values in label values by their keys.
+	referencePositions map[string]int
+}
+
+func NewKeyValueCollector(mapping Mapping) (*KeyValueCollector, error) {
+	labelKeys, err := pattern.CompileList(mapping.KubeLabels)
+	if err != nil {
+		return nil, err
+	}
+	annotationKeys, err := pattern.CompileList(mapping.KubeAnnotations)
+	if err != nil {
+		return nil, err
+	}
+	excludeKeys, err := pattern.CompileList(mapping.ExcludeKeys)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := compileFields(mapping.Fields)
+	if err != nil {
+		return nil, err
+	}
+	transformer, err := newTransformer(mapping.Transforms)
+	if err != nil {
+		return nil, err
+	}
+
+	resultPrometheusLabels, err := newLabelNamesBuilder(mapping).
+		meta(mapping.KubeResourceMeta...).
+		keys(keyTypeLabel, mapping.ReferenceLabels, mapping.LabelNames.Labels).
+		keys(keyTypeAnnotation, mapping.ReferenceAnnotations, mapping.LabelNames.Annotations).
+		meta("type", "key", "value").
+		build()
+	if err != nil {
+		return nil, err
+	}
+
+	desc := prometheus.NewDesc(mapping.Name, mapping.Help, resultPrometheusLabels, nil)
+	c := &KeyValueCollector{
+		mapping:        mapping,
+		collection:     make(map[uint64][][]string),
+		desc:           desc,
+		labelKeys:      labelKeys,
+		annotationKeys: annotationKeys,
+		excludeKeys:    excludeKeys,
+		fields:         fields,
+		transformer:    transformer,
+		groups:         newGroupIndex(),
+	}
+	c.limiter = newLimiter(mapping.Name, mapping.Limits)
+	c.referencePositions = make(map[string]int)
+	for i, key := range mapping.ReferenceLabels {
+		c.referencePositions[keyTypeLabel+" "+key] = len(mapping.KubeResourceMeta) + i
+	}
+	for i, key := range mapping.ReferenceAnnotations {
+		c.referencePositions[keyTypeAnnotation+" "+key] = len(mapping.KubeResourceMeta) + len(mapping.ReferenceLabels) + i
+	}
+	return c, nil
+}
+
+func (c *KeyValueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *KeyValueCollector) Collect(ch chan<- prometheus.Metric) {
+	defer observeCollect(c.mapping.Name, time.Now())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, s := range c.collection {
+		for _, labelValues := range s {
+			metric, err := prometheus.NewConstMetric(c.desc, prometheus.GaugeValue, 1, labelValues...)
+			if err != nil {
+				log.Printf("prepare gauge: %v\n", err)
+				continue
+			}
+			ch <- metric
+		}
+	}
+}
+
+func (c *KeyValueCollector) Series() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.limiter.series
+}
+
+func (c *KeyValueCollector) Store(sample Sample) {
+	sample = c.transformer.apply(sample)
+	reference := c.reference(sample)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Reference values over the limit are replaced before series are built, so they don't create new groups.
+	for key, position := range c.referencePositions {
+		value, ok := c.limiter.value(key, reference[position])
+		if !ok {
+			return
+		}
+		reference[position] = value
+	}
+
+	var series [][]string
+	series = c.appendPairs(series, reference, keyTypeLabel, c.labelKeys, sample.ResourceLabels)
+	series = c.appendPairs(series, reference, keyTypeAnnotation, c.annotationKeys, sample.ResourceAnnotations)
+	series = c.appendFields(series, reference, sample.Object)
+	series, ok := c.limitValues(series)
+	if !ok {
+		return
+	}
+
+	if len(series) == 0 {
+		c.removeObject(sample.identity())
+		return
+	}
+	group := hashLabels(reference)
+	added := len(series) - len(c.collection[group])
+	if added > 0 && !c.limiter.reserveSeries(added) {
+		c.limiter.dropSeries("keys or reference values")
+		return
+	}
+	if previous, _, empty := c.groups.assign(sample.identity(), group); empty {
+		c.deleteGroup(previous)
+	}
+	if added < 0 {
+		c.limiter.addSeries(added)
+	}
+	c.trackValues(c.collection[group], -1)
+	c.trackValues(series, 1)
+	c.collection[group] = series
+}
+
+// limitValues applies the limit of distinct values to values of keys, series with the same replaced values are
+// merged. It returns false if the sample must be dropped. Must be called with the lock held.
+func (c *KeyValueCollector) limitValues(series [][]string) ([][]string, bool) {
+	if c.mapping.Limits.MaxValuesPerKey <= 0 {
+		return series, true
+	}
+	result := series[:0]
+	seen := make(map[uint64]struct{}, len(series))
+	for _, labelValues := range series {
+		n := len(labelValues)
+		value, ok := c.limiter.value(labelValues[n-3]+" "+labelValues[n-2], labelValues[n-1])
+		if !ok {
+			return nil, false
+		}
+		labelValues[n-1] = value
+		if _, ok := seen[hashLabels(labelValues)]; ok {
+			continue
+		}
+		seen[hashLabels(labelValues)] = struct{}{}
+		result = append(result, labelValues)
+	}
+	return result, true
+}
+
+// trackValues counts values of reference keys and keys of series for the limiter. Must be called with the lock held.
+func (c *KeyValueCollector) trackValues(series [][]string, delta int) {
+	for _, labelValues := range series {
+		for key, position := range c.referencePositions {
+			c.limiter.track(key, labelValues[position], delta)
+		}
+		n := len(labelValues)
+		c.limiter.track(labelValues[n-3]+" "+labelValues[n-2], labelValues[n-1], delta)
+	}
+}
+
+// deleteGroup deletes series of the group. Must be called with the lock held.
+func (c *KeyValueCollector) deleteGroup(group uint64) {
+	c.limiter.addSeries(-len(c.collection[group]))
+	c.trackValues(c.collection[group], -1)
+	delete(c.collection, group)
+}
+
+// Clear removes the object from its reference group, series of the group are removed with the last member.
+func (c *KeyValueCollector) Clear(sample Sample) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeObject(sample.identity())
+}
+
+func (c *KeyValueCollector) Reconcile(live []Sample) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	orphans := c.groups.orphans(identities(live))
+	for _, object := range orphans {
+		c.removeObject(object)
+	}
+	return len(orphans)
+}
+
+// removeObject removes the object from its group and series of the group if it has no members anymore.
+// Must be called with the lock held.
+func (c *KeyValueCollector) removeObject(object string) {
+	if group, empty := c.groups.remove(object); empty {
+		c.deleteGroup(group)
+	}
+}
+
+// reference returns label values identifying the resource.
+func (c *KeyValueCollector) reference(sample Sample) []string {
+	reference := ConcatMultipleSlices(
+		[][]string{
+			compareLabelsSliceWithMap(c.mapping.ReferenceLabels, sample.ResourceLabels),
+			compareLabelsSliceWithMap(c.mapping.ReferenceAnnotations, sample.ResourceAnnotations),
+		})
+
+	if !c.mapping.OnlyLabelsAndAnnotations {
+		reference = ConcatMultipleSlices([][]string{
+			sample.ResourceMeta,
+			reference,
+		})
+	}
+	return reference
+}
+
+// appendPairs adds label values for every key matching include patterns in the sorted order.
+func (c *KeyValueCollector) appendPairs(series [][]string, reference []string, keyType string, include pattern.List, values map[string]string) [][]string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if include.MatchAny(key) && !c.excludeKeys.MatchAny(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		series = append(series, ConcatMultipleSlices(
+			[][]string{
+				reference,
+				{keyType, key, truncateValue(values[key], c.mapping.MaxValueLength)},
+			}))
+	}
+	return series
+}
+
+// appendFields adds label values for every field value found in the object in the mapping order.
+func (c *KeyValueCollector) appendFields(series [][]string, reference []string, object map[string]interface{}) [][]string {
+	for _, field := range c.fields {
+		var values []string
+		if field.List == FieldListAll {
+			values = field.values(object)
+		} else {
+			values = []string{field.value(object)}
+		}
+
+		for _, value := range values {
+			if value = c.transformer.field(field.Name, value); value == "" {
+				continue
+			}
+			series = append(series, ConcatMultipleSlices(
+				[][]string{
+					reference,
+					{keyTypeField, field.Name, truncateValue(value, c.mapping.MaxValueLength)},
+				}))
+		}
+	}
+	return series
+}
+
+// truncateValue cuts the value to maxLength bytes without breaking UTF-8 characters.
+func truncateValue(value string, maxLength int) string {
+	if maxLength <= 0 || len(value) <= maxLength {
+		return value
+	}
+	value = value[:maxLength]
+	for len(value) > 0 && !utf8.ValidString(value) {
+		value = value[:len(value)-1]
+	}
+	return value
+}
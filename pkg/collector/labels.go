@@ -0,0 +1,82 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import "fmt"
+
+// LabelNames are explicit prometheus label names of resource labels, annotations and mapping fields by their keys.
+// Explicit names are used as is without the prefix.
+type LabelNames struct {
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+	Fields      map[string]string `yaml:"fields,omitempty"`
+}
+
+// labelNamesBuilder builds prometheus label names of the mapping and checks that different keys don't map
+// to the same label name.
+type labelNamesBuilder struct {
+	prefix  string
+	names   []string
+	sources map[string]string
+}
+
+func newLabelNamesBuilder(mapping Mapping) *labelNamesBuilder {
+	prefix := ApplicationPrefix
+	if mapping.Prefix != nil {
+		prefix = *mapping.Prefix
+	}
+	return &labelNamesBuilder{prefix: prefix, sources: make(map[string]string)}
+}
+
+func (b *labelNamesBuilder) add(source, name string) {
+	if existing, ok := b.sources[name]; ok {
+		b.sources[name] = existing + ", " + source
+	} else {
+		b.sources[name] = source
+	}
+	b.names = append(b.names, name)
+}
+
+// meta adds prefixed names, e.g. resource meta or revision labels.
+func (b *labelNamesBuilder) meta(names ...string) *labelNamesBuilder {
+	for _, name := range names {
+		b.add(fmt.Sprintf("%q", name), formatPromethuesLabelName(b.prefix+name))
+	}
+	return b
+}
+
+// keys adds names of keys of the key type with explicit names if they are set.
+func (b *labelNamesBuilder) keys(keyType string, keys []string, explicit map[string]string) *labelNamesBuilder {
+	for _, key := range keys {
+		source := fmt.Sprintf("%s %q", keyType, key)
+		if name, ok := explicit[key]; ok {
+			b.add(source, name)
+			continue
+		}
+		b.add(source, formatPromethuesLabelName(b.prefix+keyType+"_"+key))
+	}
+	return b
+}
+
+// build returns label names or the error if several keys map to the same label name.
+func (b *labelNamesBuilder) build() ([]string, error) {
+	seen := make(map[string]struct{}, len(b.names))
+	for _, name := range b.names {
+		if _, ok := seen[name]; ok {
+			return nil, fmt.Errorf("label name %q is used by %s", name, b.sources[name])
+		}
+		seen[name] = struct{}{}
+	}
+	return b.names, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFormatLabelName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{name: "app", expected: "app"},
+		{name: "app.kubernetes.io/Name", expected: "app_kubernetes_io_name"},
+		{name: "helm-release", expected: "helm_release"},
+		{name: "1st", expected: "_1st"},
+		{name: "", expected: "_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if name := formatPromethuesLabelName(tt.name); name != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, name)
+			}
+		})
+	}
+}
+
+func TestLabelNames(t *testing.T) {
+	empty := ""
+	custom := "kube_"
+	tests := []struct {
+		name     string
+		mapping  Mapping
+		expected []string
+		err      string
+	}{
+		{
+			name:     "default prefix",
+			mapping:  Mapping{KubeResourceMeta: []string{"name"}, KubeLabels: []string{"app.kubernetes.io/name"}},
+			expected: []string{"annotations_exporter_name", "annotations_exporter_label_app_kubernetes_io_name", "annotations_exporter_revision"},
+		},
+		{
+			name:     "empty prefix",
+			mapping:  Mapping{Prefix: &empty, KubeAnnotations: []string{"version"}},
+			expected: []string{"annotation_version", "revision"},
+		},
+		{
+			name:     "custom prefix",
+			mapping:  Mapping{Prefix: &custom, Fields: []Field{{Name: "replicas", Path: "spec.replicas"}}},
+			expected: []string{"kube_field_replicas", "kube_revision"},
+		},
+		{
+			name: "explicit names without the prefix",
+			mapping: Mapping{KubeLabels: []string{"app"}, KubeAnnotations: []string{"version"},
+				LabelNames: LabelNames{Labels: map[string]string{"app": "application"}}},
+			expected: []string{"application", "annotations_exporter_annotation_version", "annotations_exporter_revision"},
+		},
+		{
+			name:    "sanitised names collision",
+			mapping: Mapping{KubeLabels: []string{"app.name", "app-name"}},
+			err:     `label name "annotations_exporter_label_app_name" is used by label "app.name", label "app-name"`,
+		},
+		{
+			name: "explicit name collision",
+			mapping: Mapping{Prefix: &empty, KubeLabels: []string{"app"}, KubeAnnotations: []string{"release"},
+				LabelNames: LabelNames{Labels: map[string]string{"app": "revision"}}},
+			err: `label name "revision" is used by label "app", "revision"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapping := tt.mapping
+			mapping.Name = "test_label_names"
+			mapping.MaxRevisions = 1
+			c, err := NewConstGaugeCollector(mapping)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(c.labelNames, tt.expected) {
+				t.Errorf("expected %q, got %q", tt.expected, c.labelNames)
+			}
+		})
+	}
+}
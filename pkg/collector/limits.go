@@ -0,0 +1,172 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// OverflowDrop drops samples with new values of keys over the limit.
+	OverflowDrop = "drop"
+	// OverflowReplace replaces new values of keys over the limit with OverflowValue.
+	OverflowReplace = "replace"
+	// OverflowHash replaces new values of keys over the limit with one of OverflowHashBuckets values
+	// chosen by the value hash.
+	OverflowHash = "hash"
+
+	OverflowValue       = "__overflow__"
+	OverflowHashBuckets = 16
+
+	// limitWarningInterval is the minimal interval between warnings about the same key.
+	limitWarningInterval = time.Minute
+)
+
+// Limits bound the cardinality of the metric. Zero limits are not applied.
+type Limits struct {
+	// MaxSeries is the max number of series of the metric. When it is reached, objects with new reference
+	// values are dropped, and stored objects keep storing new revisions without growing their history.
+	MaxSeries int `yaml:"max_series,omitempty"`
+	// MaxValuesPerKey is the max number of distinct values of every label, annotation and field key.
+	MaxValuesPerKey int `yaml:"max_values_per_key,omitempty"`
+	// Overflow is one of OverflowDrop (default), OverflowReplace or OverflowHash.
+	Overflow string `yaml:"overflow,omitempty"`
+}
+
+// seriesBudget is the max number of series of all metrics of the vault. It is shared by collectors, so series
+// are reserved atomically.
+type seriesBudget struct {
+	max  int64
+	used atomic.Int64
+}
+
+// reserve adds n series if they fit into the budget.
+func (b *seriesBudget) reserve(n int64) bool {
+	if b.max <= 0 {
+		b.used.Add(n)
+		return true
+	}
+	for {
+		used := b.used.Load()
+		if used+n > b.max {
+			return false
+		}
+		if b.used.CompareAndSwap(used, used+n) {
+			return true
+		}
+	}
+}
+
+// limiter applies limits of the metric. Collectors track values of keys of every stored and removed series,
+// so values of deleted objects are forgotten. Methods must be called with the collector lock held.
+type limiter struct {
+	metric string
+	limits Limits
+	budget *seriesBudget
+	series int
+	// values contain numbers of stored series with every value of every key.
+	values       map[string]map[string]int
+	lastWarnings map[string]time.Time
+}
+
+func newLimiter(metric string, limits Limits) *limiter {
+	return &limiter{
+		metric:       metric,
+		limits:       limits,
+		budget:       &seriesBudget{},
+		values:       make(map[string]map[string]int),
+		lastWarnings: make(map[string]time.Time),
+	}
+}
+
+// value returns the value of the key to store, or false if the sample must be dropped. New values are counted
+// when series with them are stored.
+func (l *limiter) value(key, value string) (string, bool) {
+	if l.limits.MaxValuesPerKey <= 0 || value == "" {
+		return value, true
+	}
+	stored := l.values[key]
+	if _, ok := stored[value]; ok || len(stored) < l.limits.MaxValuesPerKey {
+		return value, true
+	}
+
+	switch l.limits.Overflow {
+	case OverflowReplace:
+		overflowValues.WithLabelValues(l.metric).Inc()
+		l.warn(key, "%s %s has more than %d values, new values are replaced", l.metric, key, l.limits.MaxValuesPerKey)
+		return OverflowValue, true
+	case OverflowHash:
+		overflowValues.WithLabelValues(l.metric).Inc()
+		l.warn(key, "%s %s has more than %d values, new values are hashed", l.metric, key, l.limits.MaxValuesPerKey)
+		hasher := fnv.New32a()
+		_, _ = hasher.Write([]byte(value))
+		return fmt.Sprintf("%s%d", OverflowValue, hasher.Sum32()%OverflowHashBuckets), true
+	}
+	droppedSamples.WithLabelValues(l.metric, "values_limit").Inc()
+	l.warn(key, "%s %s has more than %d values, samples with new values are dropped", l.metric, key, l.limits.MaxValuesPerKey)
+	return "", false
+}
+
+// track counts the value of the key of the stored series with delta 1, and of the removed series with delta -1.
+// Empty and replaced values are not limited, so they are not counted.
+func (l *limiter) track(key, value string, delta int) {
+	if l.limits.MaxValuesPerKey <= 0 || value == "" || strings.HasPrefix(value, OverflowValue) {
+		return
+	}
+	stored, ok := l.values[key]
+	if !ok {
+		stored = make(map[string]int)
+		l.values[key] = stored
+	}
+	if stored[value] += delta; stored[value] <= 0 {
+		delete(stored, value)
+	}
+}
+
+// reserveSeries adds n more series if they fit into the metric and global limits.
+func (l *limiter) reserveSeries(n int) bool {
+	if l.limits.MaxSeries > 0 && l.series+n > l.limits.MaxSeries {
+		return false
+	}
+	if !l.budget.reserve(int64(n)) {
+		return false
+	}
+	l.series += n
+	return true
+}
+
+// dropSeries reports the sample dropped because of the series limit.
+func (l *limiter) dropSeries(key string) {
+	droppedSamples.WithLabelValues(l.metric, "series_limit").Inc()
+	l.warn("series", "%s reached the series limit, samples with new %s are dropped", l.metric, key)
+}
+
+// addSeries accounts the change of the number of stored series without limits, e.g. for removed or restored series.
+func (l *limiter) addSeries(n int) {
+	l.series += n
+	l.budget.used.Add(int64(n))
+}
+
+func (l *limiter) warn(key string, format string, args ...interface{}) {
+	if time.Since(l.lastWarnings[key]) < limitWarningInterval {
+		return
+	}
+	l.lastWarnings[key] = time.Now()
+	log.Printf("limits: "+format, args...)
+}
@@ -0,0 +1,192 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestGaugeCollectorValuesLimit(t *testing.T) {
+	version := func(uid, name, version string) Sample {
+		return testSample(uid, name, nil, map[string]string{"version": version})
+	}
+	mapping := func(maxRevisions int, overflow string) Mapping {
+		return Mapping{
+			Name:             "test_values_limit",
+			KubeResourceMeta: testResourceMeta,
+			KubeAnnotations:  []string{"version"},
+			MaxRevisions:     maxRevisions,
+			Limits:           Limits{MaxValuesPerKey: 2, Overflow: overflow},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		mapping  Mapping
+		steps    []step
+		expected []string
+	}{
+		{
+			name:     "new values over the limit are dropped",
+			mapping:  mapping(1, OverflowDrop),
+			steps:    []step{storeStep(version("a", "a", "1")), storeStep(version("b", "b", "2")), storeStep(version("c", "c", "3"))},
+			expected: []string{"v1,Pod,default,a,1,0", "v1,Pod,default,b,2,0"},
+		},
+		{
+			name:    "stored values are allowed",
+			mapping: mapping(1, OverflowDrop),
+			steps: []step{storeStep(version("a", "a", "1")), storeStep(version("b", "b", "2")),
+				storeStep(version("c", "c", "2"))},
+			expected: []string{"v1,Pod,default,a,1,0", "v1,Pod,default,b,2,0", "v1,Pod,default,c,2,0"},
+		},
+		{
+			name:    "values of deleted objects are forgotten",
+			mapping: mapping(1, OverflowDrop),
+			steps: []step{storeStep(version("a", "a", "1")), storeStep(version("b", "b", "2")),
+				clearStep(version("a", "a", "1")), storeStep(version("c", "c", "3"))},
+			expected: []string{"v1,Pod,default,b,2,0", "v1,Pod,default,c,3,0"},
+		},
+		{
+			name:    "values shared by objects are kept until the last object is deleted",
+			mapping: mapping(1, OverflowDrop),
+			steps: []step{storeStep(version("a", "a", "1")), storeStep(version("b", "b", "1")), storeStep(version("c", "c", "2")),
+				clearStep(version("a", "a", "1")), storeStep(version("d", "d", "3"))},
+			expected: []string{"v1,Pod,default,b,1,0", "v1,Pod,default,c,2,0"},
+		},
+		{
+			name:    "values of previous revisions are counted",
+			mapping: mapping(2, OverflowDrop),
+			steps: []step{storeStep(version("a", "a", "1")), storeStep(version("a", "a", "2")),
+				storeStep(version("b", "b", "3"))},
+			expected: []string{"v1,Pod,default,a,1,1", "v1,Pod,default,a,2,0"},
+		},
+		{
+			name:    "values of revisions out of the history are forgotten",
+			mapping: mapping(1, OverflowDrop),
+			steps: []step{storeStep(version("a", "a", "1")), storeStep(version("a", "a", "2")),
+				storeStep(version("b", "b", "3"))},
+			expected: []string{"v1,Pod,default,a,2,0", "v1,Pod,default,b,3,0"},
+		},
+		{
+			name:    "new values over the limit are replaced",
+			mapping: mapping(1, OverflowReplace),
+			steps: []step{storeStep(version("a", "a", "1")), storeStep(version("b", "b", "2")),
+				storeStep(version("c", "c", "3")), storeStep(version("d", "d", "4"))},
+			expected: []string{"v1,Pod,default,a,1,0", "v1,Pod,default,b,2,0", "v1,Pod,default,c,__overflow__,0",
+				"v1,Pod,default,d,__overflow__,0"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewConstGaugeCollector(tt.mapping)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, s := range tt.steps {
+				if s.clear {
+					c.Clear(s.sample)
+				} else {
+					c.Store(s.sample)
+				}
+			}
+			if series := storedSeries(c); !reflect.DeepEqual(series, tt.expected) {
+				t.Errorf("expected series %q, got %q", tt.expected, series)
+			}
+		})
+	}
+}
+
+func TestKeyValueCollectorValuesLimit(t *testing.T) {
+	c, err := NewKeyValueCollector(Mapping{
+		Name:             "test_key_value_values_limit",
+		KubeResourceMeta: testResourceMeta,
+		KubeAnnotations:  []string{"version"},
+		Mode:             ModeKeyValue,
+		Limits:           Limits{MaxValuesPerKey: 1},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := testSample("a", "a", nil, map[string]string{"version": "1"})
+	b := testSample("b", "b", nil, map[string]string{"version": "2"})
+
+	stored := func() []string {
+		var result []string
+		for _, series := range c.collection {
+			for _, labelValues := range series {
+				result = append(result, strings.Join(labelValues, ","))
+			}
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	c.Store(a)
+	c.Store(b)
+	if series, expected := stored(), []string{"v1,Pod,default,a,annotation,version,1"}; !reflect.DeepEqual(series, expected) {
+		t.Errorf("expected series %q, got %q", expected, series)
+	}
+	c.Clear(a)
+	c.Store(b)
+	if series, expected := stored(), []string{"v1,Pod,default,b,annotation,version,2"}; !reflect.DeepEqual(series, expected) {
+		t.Errorf("expected series %q, got %q", expected, series)
+	}
+}
+
+func TestSeriesBudgetConcurrentCollectors(t *testing.T) {
+	const maxSeries, collectors, objects = 10, 4, 100
+	budget := &seriesBudget{max: maxSeries}
+
+	var wg sync.WaitGroup
+	stored := make([]*GaugeCollector, collectors)
+	for i := range stored {
+		c, err := NewConstGaugeCollector(Mapping{
+			Name:             fmt.Sprintf("test_series_budget_%d", i),
+			KubeResourceMeta: testResourceMeta,
+			MaxRevisions:     1,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.limiter.budget = budget
+		stored[i] = c
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < objects; j++ {
+				name := fmt.Sprint(j)
+				c.Store(testSample(name, name, nil, nil))
+			}
+		}()
+	}
+	wg.Wait()
+
+	series := 0
+	for _, c := range stored {
+		series += len(storedSeries(c))
+	}
+	if series != maxSeries {
+		t.Errorf("expected %d series, got %d", maxSeries, series)
+	}
+	if used := budget.used.Load(); used != int64(series) {
+		t.Errorf("expected %d series to be reserved, got %d", series, used)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var valueParseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: ApplicationPrefix + "value_parse_errors_total",
+	Help: "Number of resource values that failed to parse as the sample value of the metric",
+}, []string{"metric"})
+
+// changes counts changes of tracked values of objects. Series of the object are removed when it is cleared.
+var changes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: ApplicationPrefix + "changes_total",
+	Help: "Number of changes of tracked label, annotation and field values of objects",
+}, []string{"metric", "kind", "namespace", "name", "type", "key"})
+
+var orphansRemoved = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: ApplicationPrefix + "orphans_removed_total",
+	Help: "Number of stored objects of the metric removed by the reconcile because they are not found in informer caches",
+}, []string{"metric"})
+
+var collectDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    ApplicationPrefix + "collect_duration_seconds",
+	Help:    "Time spent to collect series of the metric on scrape",
+	Buckets: prometheus.ExponentialBuckets(0.0005, 2, 12),
+}, []string{"metric"})
+
+var droppedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: ApplicationPrefix + "dropped_samples_total",
+	Help: "Number of samples of the metric dropped by cardinality limits by reason: series_limit or values_limit",
+}, []string{"metric", "reason"})
+
+var overflowValues = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: ApplicationPrefix + "overflow_values_total",
+	Help: "Number of values of the metric replaced or hashed because of the limit of distinct values per key",
+}, []string{"metric"})
+
+// RegisterMetrics registers self metrics shared by all vaults.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{valueParseErrors, changes, orphansRemoved, collectDuration, droppedSamples, overflowValues} {
+		if err := registerer.Register(c); err != nil {
+			return fmt.Errorf("self metrics registration: %v", err)
+		}
+	}
+	return nil
+}
+
+// observeCollect observes the time spent to collect the metric since the start.
+func observeCollect(metric string, start time.Time) {
+	collectDuration.WithLabelValues(metric).Observe(time.Since(start).Seconds())
+}
+
+var seriesDesc = prometheus.NewDesc(ApplicationPrefix+"series", "Number of series of the metric", []string{"metric"}, nil)
+
+// seriesCollector collects numbers of series of vault metrics on scrape.
+type seriesCollector struct {
+	vault *MetricsVault
+}
+
+func (c seriesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- seriesDesc
+}
+
+func (c seriesCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, collector := range c.vault.metrics {
+		ch <- prometheus.MustNewConstMetric(seriesDesc, prometheus.GaugeValue, float64(collector.Series()), name)
+	}
+}
+
+// registerSelfMetrics registers self metrics of the vault once, so mappings may be registered more than once.
+func (v *MetricsVault) registerSelfMetrics() error {
+	if v.selfRegistered {
+		return nil
+	}
+	if err := v.selfMetrics.Register(seriesCollector{vault: v}); err != nil {
+		return fmt.Errorf("self metrics registration: %v", err)
+	}
+	v.selfRegistered = true
+	return nil
+}
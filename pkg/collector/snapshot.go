@@ -0,0 +1,229 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format. Snapshots of older versions are migrated on load
+// with snapshotMigrations, snapshots of newer versions are rejected.
+const SnapshotVersion = 1
+
+// snapshotMigrations migrate the raw snapshot of the version to the next version.
+var snapshotMigrations = map[int]func(raw map[string]interface{}) error{}
+
+// Snapshot is the persisted revision history of metrics.
+type Snapshot struct {
+	Version int                        `json:"version"`
+	Metrics map[string]*MetricSnapshot `json:"metrics"`
+}
+
+// MetricSnapshot is the revision history of the metric. Labels are label names of the metric, the history
+// is dropped on load if they don't match the current ones, e.g. after mapping keys are changed.
+type MetricSnapshot struct {
+	Labels    []string           `json:"labels"`
+	Resources []ResourceSnapshot `json:"resources"`
+}
+
+type ResourceSnapshot struct {
+	Key       uint64             `json:"key"`
+	Revisions []RevisionSnapshot `json:"revisions"`
+}
+
+type RevisionSnapshot struct {
+	Revision    int       `json:"revision"`
+	LabelValues []string  `json:"label_values"`
+	Value       string    `json:"value,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+	Version     string    `json:"version,omitempty"`
+}
+
+// persistentCollector is the collector with the revision history that can be persisted.
+type persistentCollector interface {
+	snapshot() *MetricSnapshot
+	restore(*MetricSnapshot)
+	pruneRestored()
+}
+
+// Snapshot encodes the revision history of all metrics.
+func (v *MetricsVault) Snapshot() ([]byte, error) {
+	snapshot := Snapshot{Version: SnapshotVersion, Metrics: make(map[string]*MetricSnapshot)}
+	for name, collector := range v.metrics {
+		if c, ok := collector.(persistentCollector); ok {
+			snapshot.Metrics[name] = c.snapshot()
+		}
+	}
+	return json.Marshal(snapshot)
+}
+
+// Restore loads the revision history of metrics, it must be called before objects are stored.
+// Histories of metrics that are not registered anymore are skipped.
+func (v *MetricsVault) Restore(data []byte) error {
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		return err
+	}
+	for name, metric := range snapshot.Metrics {
+		c, ok := v.metrics[name].(persistentCollector)
+		if !ok {
+			log.Printf("snapshot: metric %s is not registered, skipping its history", name)
+			continue
+		}
+		c.restore(metric)
+	}
+	return nil
+}
+
+// PruneRestored removes restored histories of objects that were not stored since restore, it is called
+// when informers are synced and objects deleted while the exporter was down are known.
+func (v *MetricsVault) PruneRestored() {
+	for _, collector := range v.metrics {
+		if c, ok := collector.(persistentCollector); ok {
+			c.pruneRestored()
+		}
+	}
+}
+
+// Changes notifies about changes of revision histories to persist them.
+func (v *MetricsVault) Changes() <-chan struct{} {
+	return v.changes
+}
+
+func (v *MetricsVault) notifyChange() {
+	select {
+	case v.changes <- struct{}{}:
+	default:
+	}
+}
+
+func decodeSnapshot(data []byte) (*Snapshot, error) {
+	// Numbers are decoded as json.Number to keep uint64 keys precise.
+	var raw map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	number, ok := raw["version"].(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("decode snapshot: version is missing")
+	}
+	version, err := number.Int64()
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot: invalid version: %w", err)
+	}
+	for v := int(version); v < SnapshotVersion; v++ {
+		migrate, ok := snapshotMigrations[v]
+		if !ok {
+			return nil, fmt.Errorf("decode snapshot: no migration from version %d", v)
+		}
+		if err := migrate(raw); err != nil {
+			return nil, fmt.Errorf("migrate snapshot from version %d: %w", v, err)
+		}
+		raw["version"] = v + 1
+	}
+	if int(version) > SnapshotVersion {
+		return nil, fmt.Errorf("decode snapshot: unsupported version %d, the latest known is %d", int(version), SnapshotVersion)
+	}
+
+	migrated, err := json.Marshal(raw)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(migrated, snapshot); err != nil {
+		return nil, fmt.Errorf("decode snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+func (c *GaugeCollector) snapshot() *MetricSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshot := &MetricSnapshot{Labels: c.labelNames, Resources: make([]ResourceSnapshot, 0, len(c.collection))}
+	for key, resource := range c.collection {
+		resourceSnapshot := ResourceSnapshot{Key: key}
+		for revision, metric := range resource.RevisionMetrics {
+			if metric.LabelValues == nil {
+				continue
+			}
+			resourceSnapshot.Revisions = append(resourceSnapshot.Revisions, RevisionSnapshot{
+				Revision:    revision,
+				LabelValues: append([]string(nil), metric.LabelValues...),
+				Value:       strconv.FormatFloat(metric.Value, 'g', -1, 64),
+				Timestamp:   metric.Timestamp,
+				Version:     metric.Version,
+			})
+		}
+		snapshot.Resources = append(snapshot.Resources, resourceSnapshot)
+	}
+	return snapshot
+}
+
+func (c *GaugeCollector) restore(snapshot *MetricSnapshot) {
+	if !reflect.DeepEqual(snapshot.Labels, c.labelNames) {
+		log.Printf("snapshot: labels of metric %s are changed, skipping its history", c.mapping.Name)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, resource := range snapshot.Resources {
+		metrics := make([]RevisionGaugeMetric, c.mapping.MaxRevisions)
+		for _, revision := range resource.Revisions {
+			if revision.Revision < 0 || revision.Revision >= c.mapping.MaxRevisions || len(revision.LabelValues) != len(c.labelNames) {
+				continue
+			}
+			value, _ := strconv.ParseFloat(revision.Value, 64)
+			revision.LabelValues[len(revision.LabelValues)-1] = fmt.Sprint(revision.Revision)
+			metrics[revision.Revision] = RevisionGaugeMetric{
+				RevisionValue: float64(revision.Revision),
+				LabelValues:   revision.LabelValues,
+				Value:         value,
+				Timestamp:     revision.Timestamp,
+				Version:       revision.Version,
+			}
+		}
+		if metrics[0].LabelValues == nil {
+			continue
+		}
+		c.deleteGroup(resource.Key)
+		c.collection[resource.Key] = &ResourceGaugeMetric{RevisionMetrics: metrics}
+		c.limiter.addSeries(countSeries(metrics))
+		c.trackValues(metrics, 1)
+		c.restored[resource.Key] = struct{}{}
+	}
+	log.Printf("snapshot: restored history of %d objects of metric %s", len(c.restored), c.mapping.Name)
+}
+
+func (c *GaugeCollector) pruneRestored() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.restored {
+		c.deleteGroup(key)
+	}
+	if len(c.restored) > 0 {
+		log.Printf("snapshot: removed history of %d deleted objects of metric %s", len(c.restored), c.mapping.Name)
+		c.restored = make(map[uint64]struct{})
+		c.onChange()
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var testSnapshotMapping = Mapping{
+	Name:             "test_snapshot",
+	KubeResourceMeta: testResourceMeta,
+	KubeAnnotations:  []string{"version"},
+	MaxRevisions:     2,
+}
+
+func testSnapshotVault(t *testing.T, mapping Mapping) *MetricsVault {
+	t.Helper()
+	vault := NewVault(prometheus.NewRegistry())
+	if err := vault.RegisterMappings([]Mapping{mapping}); err != nil {
+		t.Fatal(err)
+	}
+	return vault
+}
+
+func testVersionSample(uid, name, version string) Sample {
+	return testSample(uid, name, nil, map[string]string{"version": version})
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	vault := testSnapshotVault(t, testSnapshotMapping)
+	for _, sample := range []Sample{
+		testVersionSample("uid-a", "a", "1"),
+		testVersionSample("uid-a", "a", "2"),
+		testVersionSample("uid-b", "b", "1"),
+	} {
+		vault.Store(testSnapshotMapping.Name, sample)
+	}
+	data, err := vault.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := testSnapshotVault(t, testSnapshotMapping)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	expected := storedSeries(vault.metrics[testSnapshotMapping.Name].(*GaugeCollector))
+	c := restored.metrics[testSnapshotMapping.Name].(*GaugeCollector)
+	if series := storedSeries(c); !reflect.DeepEqual(series, expected) {
+		t.Errorf("expected restored series %q, got %q", expected, series)
+	}
+
+	// The restored history continues, so the next version of the object shifts revisions.
+	restored.Store(testSnapshotMapping.Name, testVersionSample("uid-a", "a", "3"))
+	restored.PruneRestored()
+	expected = []string{"v1,Pod,default,a,2,1", "v1,Pod,default,a,3,0"}
+	if series := storedSeries(c); !reflect.DeepEqual(series, expected) {
+		t.Errorf("expected series %q after pruning deleted objects, got %q", expected, series)
+	}
+}
+
+func TestSnapshotRestoreChangedLabels(t *testing.T) {
+	vault := testSnapshotVault(t, testSnapshotMapping)
+	vault.Store(testSnapshotMapping.Name, testVersionSample("uid-a", "a", "1"))
+	data, err := vault.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mapping := testSnapshotMapping
+	mapping.KubeAnnotations = []string{"version", "team"}
+	restored := testSnapshotVault(t, mapping)
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if series := storedSeries(restored.metrics[mapping.Name].(*GaugeCollector)); len(series) != 0 {
+		t.Errorf("expected the history with other labels to be skipped, got %q", series)
+	}
+}
+
+func TestDecodeSnapshotVersions(t *testing.T) {
+	// The version 0 is the hypothetical format with the metrics field named differently.
+	snapshotMigrations[0] = func(raw map[string]interface{}) error {
+		raw["metrics"] = raw["collectors"]
+		delete(raw, "collectors")
+		return nil
+	}
+	t.Cleanup(func() { delete(snapshotMigrations, 0) })
+
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name: "current version",
+			data: `{"version": 1, "metrics": {"test": {"labels": ["app"]}}}`,
+		},
+		{
+			name: "migrated version",
+			data: `{"version": 0, "collectors": {"test": {"labels": ["app"]}}}`,
+		},
+		{
+			name:     "unknown version",
+			data:     `{"version": -1, "metrics": {}}`,
+			expected: "no migration from version -1",
+		},
+		{
+			name:     "newer version",
+			data:     `{"version": 2, "metrics": {}}`,
+			expected: "unsupported version 2",
+		},
+		{
+			name:     "missing version",
+			data:     `{"metrics": {}}`,
+			expected: "version is missing",
+		},
+		{
+			name:     "broken snapshot",
+			data:     `{"version": 1,`,
+			expected: "decode snapshot",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			snapshot, err := decodeSnapshot([]byte(tt.data))
+			if tt.expected != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.expected) {
+					t.Errorf("expected error containing %q, got %v", tt.expected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if snapshot.Version != SnapshotVersion {
+				t.Errorf("expected version %d, got %d", SnapshotVersion, snapshot.Version)
+			}
+			if metric := snapshot.Metrics["test"]; metric == nil || !reflect.DeepEqual(metric.Labels, []string{"app"}) {
+				t.Errorf("expected metric labels [app], got %v", metric)
+			}
+		})
+	}
+}
+
+func TestSnapshotKeepsLargeKeys(t *testing.T) {
+	data, err := json.Marshal(Snapshot{Version: SnapshotVersion, Metrics: map[string]*MetricSnapshot{
+		"test": {Resources: []ResourceSnapshot{{Key: 1<<64 - 1}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := decodeSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := snapshot.Metrics["test"].Resources[0].Key; key != 1<<64-1 {
+		t.Errorf("expected the key to be precise, got %d", key)
+	}
+}
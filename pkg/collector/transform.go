@@ -0,0 +1,291 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	// HashSHA256 replaces the value with the hex encoded SHA-256 hash.
+	HashSHA256 = "sha256"
+
+	urlQueryPrefix = "query:"
+)
+
+// Transform is the pipeline of steps applied to the value of the resource label, annotation or mapping field
+// before it is exported. Exactly one of Label, Annotation or Field is set.
+type Transform struct {
+	Label      string          `yaml:"label,omitempty"`
+	Annotation string          `yaml:"annotation,omitempty"`
+	Field      string          `yaml:"field,omitempty"`
+	Steps      []TransformStep `yaml:"steps"`
+}
+
+// TransformStep is the single value transformation, exactly one of them is set in the step.
+type TransformStep struct {
+	// Regex replaces the value with the first capture group of the match or the whole match if there are no groups.
+	// The value is empty if nothing matches. With Replace all matches are replaced by the template with $1 references.
+	Regex   string  `yaml:"regex,omitempty"`
+	Replace *string `yaml:"replace,omitempty"`
+
+	Lowercase bool `yaml:"lowercase,omitempty"`
+
+	// Truncate cuts the value to the number of bytes and adds the suffix if the value is cut.
+	Truncate int    `yaml:"truncate,omitempty"`
+	Suffix   string `yaml:"suffix,omitempty"`
+
+	// Hash is HashSHA256.
+	Hash string `yaml:"hash,omitempty"`
+
+	// URL is the component of the URL value: scheme, user, host, hostname, port, path, query, fragment
+	// or query:<name> for the query parameter.
+	URL string `yaml:"url,omitempty"`
+
+	// JSONPath extracts the first value from the JSON encoded value.
+	JSONPath string `yaml:"json_path,omitempty"`
+
+	// Default replaces the empty or missing value.
+	Default string `yaml:"default,omitempty"`
+}
+
+type transformFunc func(value string) string
+
+// ValidateTransformStep checks that the step sets exactly one valid transformation.
+func ValidateTransformStep(step TransformStep) error {
+	_, err := compileTransformStep(step)
+	return err
+}
+
+func compileTransformStep(step TransformStep) (transformFunc, error) {
+	var result []transformFunc
+	if step.Regex != "" {
+		re, err := regexp.Compile(step.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex %q: %w", step.Regex, err)
+		}
+		if step.Replace != nil {
+			template := *step.Replace
+			result = append(result, func(value string) string {
+				return re.ReplaceAllString(value, template)
+			})
+		} else {
+			result = append(result, func(value string) string {
+				match := re.FindStringSubmatch(value)
+				switch len(match) {
+				case 0:
+					return ""
+				case 1:
+					return match[0]
+				}
+				return match[1]
+			})
+		}
+	} else if step.Replace != nil {
+		return nil, fmt.Errorf("replace can be used only with regex")
+	}
+
+	if step.Lowercase {
+		result = append(result, strings.ToLower)
+	}
+
+	if step.Truncate < 0 {
+		return nil, fmt.Errorf("truncate must not be negative")
+	}
+	if step.Truncate > 0 {
+		length, suffix := step.Truncate, step.Suffix
+		result = append(result, func(value string) string {
+			if len(value) <= length {
+				return value
+			}
+			return truncateValue(value, length) + suffix
+		})
+	} else if step.Suffix != "" {
+		return nil, fmt.Errorf("suffix can be used only with truncate")
+	}
+
+	switch step.Hash {
+	case "":
+	case HashSHA256:
+		result = append(result, func(value string) string {
+			sum := sha256.Sum256([]byte(value))
+			return hex.EncodeToString(sum[:])
+		})
+	default:
+		return nil, fmt.Errorf("unknown hash %q, must be %s", step.Hash, HashSHA256)
+	}
+
+	if step.URL != "" {
+		component, err := urlComponent(step.URL)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, func(value string) string {
+			u, err := url.Parse(value)
+			if err != nil {
+				return ""
+			}
+			return component(u)
+		})
+	}
+
+	if step.JSONPath != "" {
+		path, err := ParseFieldPath(step.JSONPath)
+		if err != nil {
+			return nil, err
+		}
+		field := &fieldPath{Field: Field{Name: step.JSONPath, List: FieldListFirst}, path: path}
+		result = append(result, func(value string) string {
+			var object map[string]interface{}
+			if err := json.Unmarshal([]byte(value), &object); err != nil {
+				return ""
+			}
+			return field.value(object)
+		})
+	}
+
+	if step.Default != "" {
+		defaultValue := step.Default
+		result = append(result, func(value string) string {
+			if value == "" {
+				return defaultValue
+			}
+			return value
+		})
+	}
+
+	if len(result) != 1 {
+		return nil, fmt.Errorf("exactly one transformation is required in the step, got %d", len(result))
+	}
+	if step.Default != "" {
+		return result[0], nil
+	}
+	// Missing and empty values are only replaced by the default value, other steps would make values from nothing,
+	// e.g. the hash of the empty string.
+	f := result[0]
+	return func(value string) string {
+		if value == "" {
+			return ""
+		}
+		return f(value)
+	}, nil
+}
+
+func urlComponent(component string) (func(u *url.URL) string, error) {
+	if strings.HasPrefix(component, urlQueryPrefix) {
+		name := strings.TrimPrefix(component, urlQueryPrefix)
+		return func(u *url.URL) string { return u.Query().Get(name) }, nil
+	}
+	switch component {
+	case "scheme":
+		return func(u *url.URL) string { return u.Scheme }, nil
+	case "user":
+		return func(u *url.URL) string { return u.User.Username() }, nil
+	case "host":
+		return func(u *url.URL) string { return u.Host }, nil
+	case "hostname":
+		return func(u *url.URL) string { return u.Hostname() }, nil
+	case "port":
+		return func(u *url.URL) string { return u.Port() }, nil
+	case "path":
+		return func(u *url.URL) string { return u.Path }, nil
+	case "query":
+		return func(u *url.URL) string { return u.RawQuery }, nil
+	case "fragment":
+		return func(u *url.URL) string { return u.Fragment }, nil
+	}
+	return nil, fmt.Errorf("unknown url component %q", component)
+}
+
+// transformPipeline applies steps in order.
+type transformPipeline []transformFunc
+
+func (p transformPipeline) apply(value string) string {
+	for _, step := range p {
+		value = step(value)
+	}
+	return value
+}
+
+// transformer applies transforms to resource labels and annotations of the sample.
+type transformer struct {
+	labels      map[string]transformPipeline
+	annotations map[string]transformPipeline
+	fields      map[string]transformPipeline
+}
+
+func newTransformer(transforms []Transform) (*transformer, error) {
+	t := &transformer{
+		labels:      make(map[string]transformPipeline),
+		annotations: make(map[string]transformPipeline),
+		fields:      make(map[string]transformPipeline),
+	}
+	for _, transform := range transforms {
+		pipeline := make(transformPipeline, len(transform.Steps))
+		for i, step := range transform.Steps {
+			f, err := compileTransformStep(step)
+			if err != nil {
+				return nil, err
+			}
+			pipeline[i] = f
+		}
+
+		switch {
+		case transform.Label != "":
+			t.labels[transform.Label] = append(t.labels[transform.Label], pipeline...)
+		case transform.Annotation != "":
+			t.annotations[transform.Annotation] = append(t.annotations[transform.Annotation], pipeline...)
+		case transform.Field != "":
+			t.fields[transform.Field] = append(t.fields[transform.Field], pipeline...)
+		}
+	}
+	return t, nil
+}
+
+// apply returns the sample with transformed labels and annotations. Maps of the original sample are not modified.
+func (t *transformer) apply(sample Sample) Sample {
+	sample.ResourceLabels = transformValues(t.labels, sample.ResourceLabels)
+	sample.ResourceAnnotations = transformValues(t.annotations, sample.ResourceAnnotations)
+	return sample
+}
+
+// field returns the transformed value of the mapping field.
+func (t *transformer) field(name, value string) string {
+	return t.fields[name].apply(value)
+}
+
+func transformValues(pipelines map[string]transformPipeline, values map[string]string) map[string]string {
+	if len(pipelines) == 0 {
+		return values
+	}
+	result := make(map[string]string, len(values)+len(pipelines))
+	for key, value := range values {
+		result[key] = value
+	}
+	for key, pipeline := range pipelines {
+		// Missing values are passed to the pipeline too, so they can get the default value.
+		if value := pipeline.apply(result[key]); value != "" {
+			result[key] = value
+		} else {
+			delete(result, key)
+		}
+	}
+	return result
+}
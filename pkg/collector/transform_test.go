@@ -0,0 +1,101 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTransformerMissingValues(t *testing.T) {
+	replace := "x"
+	tests := []struct {
+		name     string
+		steps    []TransformStep
+		values   map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "hash of the missing value",
+			steps:    []TransformStep{{Hash: HashSHA256}},
+			values:   map[string]string{},
+			expected: map[string]string{},
+		},
+		{
+			name:     "hash of the empty value",
+			steps:    []TransformStep{{Hash: HashSHA256}},
+			values:   map[string]string{"key": ""},
+			expected: map[string]string{},
+		},
+		{
+			name:     "hash of the value",
+			steps:    []TransformStep{{Hash: HashSHA256}},
+			values:   map[string]string{"key": "a"},
+			expected: map[string]string{"key": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb"},
+		},
+		{
+			name:     "regex replace of the missing value",
+			steps:    []TransformStep{{Regex: "^$", Replace: &replace}},
+			values:   map[string]string{},
+			expected: map[string]string{},
+		},
+		{
+			name:     "truncate of the missing value",
+			steps:    []TransformStep{{Truncate: 1, Suffix: "..."}},
+			values:   map[string]string{},
+			expected: map[string]string{},
+		},
+		{
+			name:     "default of the missing value",
+			steps:    []TransformStep{{Hash: HashSHA256}, {Default: "none"}},
+			values:   map[string]string{},
+			expected: map[string]string{"key": "none"},
+		},
+		{
+			name:     "steps after the default",
+			steps:    []TransformStep{{Default: "None"}, {Lowercase: true}},
+			values:   map[string]string{},
+			expected: map[string]string{"key": "none"},
+		},
+		{
+			name:     "default of the value emptied by the regex",
+			steps:    []TransformStep{{Regex: "^v([0-9]+)"}, {Default: "unknown"}},
+			values:   map[string]string{"key": "latest"},
+			expected: map[string]string{"key": "unknown"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transformer, err := newTransformer([]Transform{{Annotation: "key", Steps: tt.steps}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := transformer.apply(Sample{ResourceAnnotations: tt.values}).ResourceAnnotations
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestTransformerMissingFields(t *testing.T) {
+	transformer, err := newTransformer([]Transform{{Field: "image", Steps: []TransformStep{{Hash: HashSHA256}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := transformer.field("image", ""); value != "" {
+		t.Errorf("expected the missing field to stay empty, got %q", value)
+	}
+}
@@ -63,18 +63,17 @@ func hashLabels(labels []string) uint64 {
 	return hasher.Sum64()
 }
 
-func formatPromethuesLabelSlice(slice []string, prefix string) []string {
-	result := make([]string, len(slice))
-	for i, kubeMeta := range slice {
-		result[i] = formatPromethuesLabelName(prefix + kubeMeta)
-	}
-	return result
-}
-
+// formatPromethuesLabelName converts the name to the valid prometheus label name: the name is lowercased
+// and all characters except letters, digits and underscores are replaced with underscores.
 func formatPromethuesLabelName(labelName string) string {
-	labelName = strings.ToLower(labelName)
-	labelName = strings.ReplaceAll(labelName, "/", "_")
-	labelName = strings.ReplaceAll(labelName, ".", "_")
-	labelName = strings.ReplaceAll(labelName, "-", "_")
+	labelName = strings.Map(func(r rune) rune {
+		if r == '_' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(labelName))
+	if labelName == "" || labelName[0] >= '0' && labelName[0] <= '9' {
+		labelName = "_" + labelName
+	}
 	return labelName
 }
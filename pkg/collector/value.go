@@ -0,0 +1,125 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// ValueTypeFloat parses the value as the float number.
+	ValueTypeFloat = "float"
+	// ValueTypeTime parses the value as RFC3339 time and exports it as unix seconds.
+	ValueTypeTime = "time"
+	// ValueTypeDuration parses the value as Go duration, e.g. 1h30m, and exports it as seconds.
+	ValueTypeDuration = "duration"
+
+	// OnErrorSkip doesn't export the resource while its value can't be parsed.
+	OnErrorSkip = "skip"
+	// OnErrorNaN exports NaN if the value can't be parsed.
+	OnErrorNaN = "nan"
+	// OnErrorDefault exports the default value if the value can't be parsed.
+	OnErrorDefault = "default"
+)
+
+// ValueFrom sets the sample value from the resource label, annotation or field instead of the revision number.
+// Exactly one of Label, Annotation or Field is set.
+type ValueFrom struct {
+	Label      string `yaml:"label,omitempty"`
+	Annotation string `yaml:"annotation,omitempty"`
+	// Field is the JSONPath of the object field, the first value found is used.
+	Field string `yaml:"field,omitempty"`
+
+	// Type is one of ValueTypeFloat (default), ValueTypeTime or ValueTypeDuration.
+	Type string `yaml:"type,omitempty"`
+	// OnError is one of OnErrorSkip (default), OnErrorNaN or OnErrorDefault. It is applied to missing values too.
+	OnError string  `yaml:"on_error,omitempty"`
+	Default float64 `yaml:"default,omitempty"`
+}
+
+type valueSource struct {
+	*ValueFrom
+	metricName string
+	field      *fieldPath
+}
+
+func newValueSource(metricName string, valueFrom *ValueFrom) (*valueSource, error) {
+	if valueFrom == nil {
+		return nil, nil
+	}
+	source := &valueSource{ValueFrom: valueFrom, metricName: metricName}
+	if valueFrom.Field != "" {
+		path, err := ParseFieldPath(valueFrom.Field)
+		if err != nil {
+			return nil, err
+		}
+		source.field = &fieldPath{Field: Field{Name: "value", List: FieldListFirst}, path: path}
+	}
+	return source, nil
+}
+
+// value returns the sample value and false if the resource should be skipped.
+func (s *valueSource) value(sample Sample) (float64, bool) {
+	var raw string
+	var found bool
+	switch {
+	case s.Label != "":
+		raw, found = sample.ResourceLabels[s.Label]
+	case s.Annotation != "":
+		raw, found = sample.ResourceAnnotations[s.Annotation]
+	case s.field != nil:
+		raw = s.field.value(sample.Object)
+		found = raw != ""
+	}
+
+	if found {
+		value, err := ParseValue(raw, s.Type)
+		if err == nil {
+			return value, true
+		}
+		valueParseErrors.WithLabelValues(s.metricName).Inc()
+	}
+
+	switch s.OnError {
+	case OnErrorNaN:
+		return math.NaN(), true
+	case OnErrorDefault:
+		return s.Default, true
+	}
+	return 0, false
+}
+
+// ParseValue parses the string value of the value type.
+func ParseValue(raw, valueType string) (float64, error) {
+	switch valueType {
+	case "", ValueTypeFloat:
+		return strconv.ParseFloat(raw, 64)
+	case ValueTypeTime:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return 0, err
+		}
+		return float64(t.UnixNano()) / float64(time.Second), nil
+	case ValueTypeDuration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return 0, err
+		}
+		return d.Seconds(), nil
+	}
+	return 0, fmt.Errorf("unknown value type %q", valueType)
+}
@@ -0,0 +1,111 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package collector
+
+import (
+	"math"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestParseValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		valueType string
+		expected  float64
+		invalid   bool
+	}{
+		{name: "float by default", raw: "1.5", expected: 1.5},
+		{name: "float", raw: "-3e2", valueType: ValueTypeFloat, expected: -300},
+		{name: "invalid float", raw: "10Mi", valueType: ValueTypeFloat, invalid: true},
+		{name: "time", raw: "2022-01-02T03:04:05Z", valueType: ValueTypeTime, expected: 1641092645},
+		{name: "time with fraction", raw: "2022-01-02T03:04:05.5+01:00", valueType: ValueTypeTime, expected: 1641089045.5},
+		{name: "invalid time", raw: "2022-01-02", valueType: ValueTypeTime, invalid: true},
+		{name: "duration", raw: "1h30m", valueType: ValueTypeDuration, expected: 5400},
+		{name: "invalid duration", raw: "90", valueType: ValueTypeDuration, invalid: true},
+		{name: "unknown type", raw: "true", valueType: "bool", invalid: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := ParseValue(tt.raw, tt.valueType)
+			if tt.invalid {
+				if err == nil {
+					t.Errorf("expected error, got %v", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, value)
+			}
+		})
+	}
+}
+
+func TestValueSourceOnError(t *testing.T) {
+	tests := []struct {
+		name       string
+		valueFrom  ValueFrom
+		annotation string
+		expected   float64
+		skip       bool
+		parseError bool
+	}{
+		{name: "parsed value", valueFrom: ValueFrom{Annotation: "replicas"}, annotation: "3", expected: 3},
+		{name: "skip by default", valueFrom: ValueFrom{Annotation: "replicas"}, annotation: "three", skip: true, parseError: true},
+		{name: "nan", valueFrom: ValueFrom{Annotation: "replicas", OnError: OnErrorNaN}, annotation: "three",
+			expected: math.NaN(), parseError: true},
+		{name: "default", valueFrom: ValueFrom{Annotation: "replicas", OnError: OnErrorDefault, Default: -1}, annotation: "three",
+			expected: -1, parseError: true},
+		{name: "missing value is not a parse error", valueFrom: ValueFrom{Annotation: "replicas", OnError: OnErrorDefault, Default: -1},
+			expected: -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metricName := "test_value_" + tt.name
+			source, err := newValueSource(metricName, &tt.valueFrom)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sample := testSample("uid-a", "a", nil, nil)
+			if tt.annotation != "" {
+				sample.ResourceAnnotations = map[string]string{"replicas": tt.annotation}
+			}
+
+			errors := valueParseErrors.WithLabelValues(metricName)
+			before := testutil.ToFloat64(errors)
+
+			value, ok := source.value(sample)
+			if ok == tt.skip {
+				t.Fatalf("expected skip %v, got %v", tt.skip, !ok)
+			}
+			if ok && !(value == tt.expected || math.IsNaN(value) && math.IsNaN(tt.expected)) {
+				t.Errorf("expected %v, got %v", tt.expected, value)
+			}
+			expectedErrors := 0.0
+			if tt.parseError {
+				expectedErrors = 1
+			}
+			if count := testutil.ToFloat64(errors) - before; count != expectedErrors {
+				t.Errorf("expected %v parse errors, got %v", expectedErrors, count)
+			}
+		})
+	}
+}
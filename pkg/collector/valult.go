@@ -15,47 +15,171 @@ package collector
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+const (
+	// ModeRevisions exports one series per resource with all mapping keys as labels and stores revisions of values.
+	ModeRevisions = "revisions"
+	// ModeKeyValue exports one series per resource key and value pair.
+	ModeKeyValue = "key_value"
+
+	// RevisionTimestampsMetric exports the time each revision was first observed as the companion
+	// <metric>_revision_timestamp_seconds metric.
+	RevisionTimestampsMetric = "metric"
+	// RevisionTimestampsValue exports the time each revision was first observed as the sample value.
+	RevisionTimestampsValue = "value"
+
+	// RevisionVersionGeneration labels revisions with the object generation they were first observed with.
+	RevisionVersionGeneration = "generation"
+	// RevisionVersionResourceVersion labels revisions with the object resource version they were first observed with.
+	RevisionVersionResourceVersion = "resource_version"
+)
+
 type MetricsVault struct {
 	metrics map[string]ConstMetricCollector
+	// registry contains metrics collectors, self metrics of the exporter are registered separately.
+	registry *prometheus.Registry
+	// changes is notified when revision histories are changed.
+	changes chan struct{}
+	// budget is the max number of series of all metrics.
+	budget *seriesBudget
+	// selfMetrics registers self metrics of the vault, selfRegistered is set after they are registered.
+	selfMetrics    prometheus.Registerer
+	selfRegistered bool
 }
 
 type Mapping struct {
 	Name string `yaml:"name"`
 	Help string `yaml:"help,omitempty"`
 
-	ReferenceAnnotations []string `yaml:"reference_annotations"`
-	ReferenceLabels      []string `yaml:"reference_labels"`
+	ReferenceAnnotations []string `yaml:"reference_annotations,omitempty"`
+	ReferenceLabels      []string `yaml:"reference_labels,omitempty"`
 
-	KubeResourceMeta []string `yaml:"resource_meta"`
-	KubeAnnotations  []string `yaml:"kube_labels,omitempty"`
-	KubeLabels       []string `yaml:"kube_annotations,omitempty"`
+	KubeResourceMeta []string `yaml:"resource_meta,omitempty"`
+	KubeAnnotations  []string `yaml:"kube_annotations,omitempty"`
+	KubeLabels       []string `yaml:"kube_labels,omitempty"`
 
 	MaxRevisions int `yaml:"max_revisions,omitempty"`
 
 	OnlyLabelsAndAnnotations bool `yaml:"only_labels_and_annotations,omitempty"`
+
+	// Mode is one of ModeRevisions (default) or ModeKeyValue.
+	Mode string `yaml:"mode,omitempty"`
+	// ExcludeKeys are patterns of keys that are never exported.
+	ExcludeKeys []string `yaml:"exclude_keys,omitempty"`
+	// MaxValueLength truncates values in ModeKeyValue, zero means no limit.
+	MaxValueLength int `yaml:"max_value_length,omitempty"`
+	// Fields are exported from the object by JSONPath.
+	Fields []Field `yaml:"fields,omitempty"`
+	// Prefix of label names, ApplicationPrefix if not set.
+	Prefix *string `yaml:"prefix,omitempty"`
+	// LabelNames are explicit label names of keys.
+	LabelNames LabelNames `yaml:"label_names,omitempty"`
+
+	// Transforms are applied to values of resource labels, annotations and mapping fields before they are exported.
+	Transforms []Transform `yaml:"transforms,omitempty"`
+	// ValueFrom sets the sample value from the resource in ModeRevisions.
+	ValueFrom *ValueFrom `yaml:"value_from,omitempty"`
+
+	// RevisionTimestamps is one of RevisionTimestampsMetric or RevisionTimestampsValue, timestamps are not exported if empty.
+	RevisionTimestamps string `yaml:"revision_timestamps,omitempty"`
+	// RevisionVersion is one of RevisionVersionGeneration or RevisionVersionResourceVersion.
+	RevisionVersion string `yaml:"revision_version,omitempty"`
+
+	// Aggregate stores revisions of reference groups only when all members of the group agree on tracked values,
+	// and exports member counts and conflicting values of groups.
+	Aggregate bool `yaml:"aggregate,omitempty"`
+
+	// Limits bound the cardinality of the metric.
+	Limits Limits `yaml:"limits,omitempty"`
 }
 
 type Sample struct {
 	ResourceLabels      map[string]string
 	ResourceAnnotations map[string]string
 	ResourceMeta        []string
+	// Object is the object content for mapping fields.
+	Object map[string]interface{}
+
+	UID             string
+	Generation      int64
+	ResourceVersion string
 }
 
-func NewVault() *MetricsVault {
-	return &MetricsVault{metrics: make(map[string]ConstMetricCollector)}
+// identity returns the object UID or its resource meta if the UID is not set.
+func (s Sample) identity() string {
+	if s.UID != "" {
+		return s.UID
+	}
+	return strings.Join(s.ResourceMeta, "/")
+}
+
+// object returns the kind, the namespace and the name of the object from the resource meta.
+func (s Sample) object() (kind, namespace, name string, ok bool) {
+	if len(s.ResourceMeta) != 4 {
+		return "", "", "", false
+	}
+	return s.ResourceMeta[1], s.ResourceMeta[2], s.ResourceMeta[3], true
+}
+
+// version returns the object version of the kind for revisions.
+func (s Sample) version(kind string) string {
+	switch kind {
+	case RevisionVersionGeneration:
+		return fmt.Sprint(s.Generation)
+	case RevisionVersionResourceVersion:
+		return s.ResourceVersion
+	}
+	return ""
+}
+
+// NewVault returns the vault, which registers its self metrics with the registerer.
+func NewVault(selfMetrics prometheus.Registerer) *MetricsVault {
+	return &MetricsVault{metrics: make(map[string]ConstMetricCollector), registry: prometheus.NewRegistry(), changes: make(chan struct{}, 1),
+		budget: &seriesBudget{}, selfMetrics: selfMetrics}
+}
+
+// SetMaxSeries sets the max number of series of all metrics, zero means no limit. It must be called before
+// mappings are registered.
+func (v *MetricsVault) SetMaxSeries(maxSeries int) {
+	v.budget.max = int64(maxSeries)
+}
+
+// Registry returns the registry of metrics of the vault.
+func (v *MetricsVault) Registry() *prometheus.Registry {
+	return v.registry
 }
 
 func (v *MetricsVault) RegisterMappings(mappings []Mapping) error {
+	if err := v.registerSelfMetrics(); err != nil {
+		return err
+	}
 	for _, mapping := range mappings {
 
-		collector := NewConstGaugeCollector(mapping)
+		var collector ConstMetricCollector
+		switch mapping.Mode {
+		case ModeKeyValue:
+			c, err := NewKeyValueCollector(mapping)
+			if err != nil {
+				return fmt.Errorf("mapping %s: %v", mapping.Name, err)
+			}
+			c.limiter.budget = v.budget
+			collector = c
+		default:
+			c, err := NewConstGaugeCollector(mapping)
+			if err != nil {
+				return fmt.Errorf("mapping %s: %v", mapping.Name, err)
+			}
+			c.onChange = v.notifyChange
+			c.limiter.budget = v.budget
+			collector = c
+		}
 		v.metrics[mapping.Name] = collector
 
-		if err := prometheus.Register(collector); err != nil {
+		if err := v.registry.Register(collector); err != nil {
 			return fmt.Errorf("mapping registration: %v", err)
 		}
 	}
@@ -69,3 +193,10 @@ func (v *MetricsVault) Store(index string, sample Sample) {
 func (v *MetricsVault) Clear(index string, sample Sample) {
 	v.metrics[index].Clear(sample)
 }
+
+// Reconcile removes objects of the metric that are not live anymore, e.g. if their delete events were missed.
+func (v *MetricsVault) Reconcile(index string, live []Sample) int {
+	removed := v.metrics[index].Reconcile(live)
+	orphansRemoved.WithLabelValues(index).Add(float64(removed))
+	return removed
+}
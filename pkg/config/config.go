@@ -0,0 +1,158 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the declarative exporter configuration. Top-level namespaces, namespace selector, excluded namespaces,
+// resources, excluded resources, max revisions and limits are used as defaults for mappings that do not declare their own.
+type Config struct {
+	Namespaces        []string   `yaml:"namespaces,omitempty"`
+	NamespaceSelector string     `yaml:"namespace_selector,omitempty"`
+	ExcludeNamespaces []string   `yaml:"exclude_namespaces,omitempty"`
+	Resources         []Resource `yaml:"resources,omitempty"`
+	ExcludeResources  []string   `yaml:"exclude_resources,omitempty"`
+	MaxRevisions      int        `yaml:"max_revisions,omitempty"`
+	// Limits are cardinality limits of every mapping, MaxSeries limits the number of series of all mappings.
+	Limits    collector.Limits `yaml:"limits,omitempty"`
+	MaxSeries int              `yaml:"max_series,omitempty"`
+
+	// DiscoveryInterval enables periodic api discovery to watch resources installed after startup.
+	DiscoveryInterval time.Duration `yaml:"discovery_interval,omitempty"`
+	// ReconcileInterval is the interval to remove stored objects that are not found in informer caches.
+	ReconcileInterval time.Duration `yaml:"reconcile_interval,omitempty"`
+
+	Persistence Persistence `yaml:"persistence,omitempty"`
+
+	Mappings []Mapping `yaml:"mappings,omitempty"`
+
+	// path and root are kept to report validation errors with the position in the source file.
+	path string
+	root *yaml.Node
+}
+
+const (
+	PersistenceFile      = "file"
+	PersistenceConfigMap = "configmap"
+)
+
+// Persistence configures the backend to save revision histories to, so they survive exporter restarts.
+// Histories are not persisted if the backend is empty.
+type Persistence struct {
+	// Backend is one of PersistenceFile or PersistenceConfigMap.
+	Backend string `yaml:"backend,omitempty"`
+	// Path is the snapshot file path of PersistenceFile backend.
+	Path string `yaml:"path,omitempty"`
+	// Namespace and Name are the ConfigMap of PersistenceConfigMap backend.
+	Namespace string `yaml:"namespace,omitempty"`
+	Name      string `yaml:"name,omitempty"`
+	// Debounce is the minimal interval between saves.
+	Debounce time.Duration `yaml:"debounce,omitempty"`
+}
+
+// Mapping binds the collector mapping to the Kubernetes resources and namespaces it is fed from.
+// Kinds optionally restrict the objects of the resources to the specified kinds, namespace selector
+// restricts namespaces to ones with matching labels, and namespaces matching exclude patterns are skipped.
+type Mapping struct {
+	collector.Mapping `yaml:",inline"`
+
+	Resources         []Resource `yaml:"resources,omitempty"`
+	ExcludeResources  []string   `yaml:"exclude_resources,omitempty"`
+	Namespaces        []string   `yaml:"namespaces,omitempty"`
+	NamespaceSelector string     `yaml:"namespace_selector,omitempty"`
+	ExcludeNamespaces []string   `yaml:"exclude_namespaces,omitempty"`
+	Kinds             []string   `yaml:"kinds,omitempty"`
+}
+
+// Resource is the resource string (<resource>/<version>/<api> or <resource>/<api>, patterns of them, the discovery
+// category or "*" for all namespaced resources) with optional label
+// and field selectors applied on watch. It is specified as a plain resource string or as an object.
+type Resource struct {
+	Resource      string `yaml:"resource"`
+	LabelSelector string `yaml:"label_selector,omitempty"`
+	FieldSelector string `yaml:"field_selector,omitempty"`
+}
+
+// NewResources converts resource strings to resources without selectors.
+func NewResources(resources []string) []Resource {
+	result := make([]Resource, len(resources))
+	for i, resource := range resources {
+		result[i] = Resource{Resource: resource}
+	}
+	return result
+}
+
+// UnmarshalYAML decodes the resource from the plain string or the object. Unknown object fields are rejected,
+// because custom unmarshalers don't inherit the decoder settings.
+func (r *Resource) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&r.Resource)
+	}
+
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			switch key := node.Content[i]; key.Value {
+			case "resource", "label_selector", "field_selector":
+			default:
+				return fmt.Errorf("line %d: field %s not found in type config.Resource", key.Line, key.Value)
+			}
+		}
+	}
+
+	type plain Resource
+	return node.Decode((*plain)(r))
+}
+
+// Load reads the configuration file in YAML or JSON format. Unknown fields are rejected.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	cfg, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.path = path
+	return cfg, nil
+}
+
+// Parse decodes the configuration from YAML or JSON data. Unknown fields are rejected.
+func Parse(data []byte) (*Config, error) {
+	cfg := &Config{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, err
+	}
+	cfg.root = root
+	return cfg, nil
+}
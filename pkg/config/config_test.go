@@ -0,0 +1,199 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseUnknownFields(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected string
+	}{
+		{
+			name:     "top-level field",
+			data:     "namespace: [default]\n",
+			expected: "line 1: field namespace not found",
+		},
+		{
+			name: "mapping field",
+			data: `mappings:
+  - name: test
+    kube_label: [app]
+`,
+			expected: "line 3: field kube_label not found",
+		},
+		{
+			name: "resource object field",
+			data: `resources:
+  - resource: deployments/apps
+    selector: app=test
+`,
+			expected: "line 3: field selector not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.expected) {
+				t.Errorf("expected error containing %q, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+func TestValidateFieldErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		expected []FieldError
+	}{
+		{
+			name: "bad mode",
+			data: `resources: [deployments/apps]
+mappings:
+  - name: test
+    max_revisions: 1
+    mode: keyvalues
+`,
+			expected: []FieldError{{Line: 5, Field: "mappings[0].mode"}},
+		},
+		{
+			name: "bad selector",
+			data: `resources: [deployments/apps]
+mappings:
+  - name: test
+    max_revisions: 1
+    resources:
+      - resource: deployments/apps
+        label_selector: "app in (a"
+`,
+			expected: []FieldError{{Line: 6, Field: "mappings[0].resources[0]"}},
+		},
+		{
+			name: "bad namespace selector",
+			data: `resources: [deployments/apps]
+namespace_selector: "env in (a"
+mappings:
+  - name: test
+    max_revisions: 1
+`,
+			expected: []FieldError{{Line: 2, Field: "namespace_selector"}},
+		},
+		{
+			name: "duplicate mapping name",
+			data: `resources: [deployments/apps]
+max_revisions: 1
+mappings:
+  - name: test
+  - name: other
+  - name: test
+`,
+			expected: []FieldError{{Line: 6, Field: "mappings[2].name"}},
+		},
+		{
+			name: "several errors",
+			data: `resources: [deployments/apps]
+max_revisions: 1
+mappings:
+  - name: test-metric
+    kube_labels: ["~("]
+`,
+			expected: []FieldError{{Line: 4, Field: "mappings[0].name"}, {Line: 5, Field: "mappings[0].kube_labels[0]"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.Validate()
+			var errs ValidationError
+			if !errors.As(err, &errs) {
+				t.Fatalf("expected validation error, got %v", err)
+			}
+			if len(errs) != len(tt.expected) {
+				t.Fatalf("expected %d errors, got %v", len(tt.expected), err)
+			}
+			for i, expected := range tt.expected {
+				if errs[i].Line != expected.Line || errs[i].Field != expected.Field {
+					t.Errorf("expected error of %s at line %d, got %v", expected.Field, expected.Line, errs[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadValidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	data := `namespaces: [default]
+resources:
+  - deployments/apps
+  - resource: secrets/v1/
+    label_selector: owner=helm
+max_revisions: 2
+mappings:
+  - name: test
+    kube_labels: [app]
+  - name: other
+    namespaces: [prod]
+    max_revisions: 5
+`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mappings inherit top-level values they don't declare.
+	test, other := cfg.Mappings[0], cfg.Mappings[1]
+	if len(test.Resources) != 2 || test.Resources[1].LabelSelector != "owner=helm" {
+		t.Errorf("expected inherited resources, got %v", test.Resources)
+	}
+	if test.MaxRevisions != 2 || other.MaxRevisions != 5 {
+		t.Errorf("expected max revisions 2 and 5, got %d and %d", test.MaxRevisions, other.MaxRevisions)
+	}
+	if len(other.Namespaces) != 1 || other.Namespaces[0] != "prod" {
+		t.Errorf("expected own namespaces, got %v", other.Namespaces)
+	}
+}
+
+func TestLoadReportsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("mappings:\n  - name: test\n    max_revisions: -1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), path+":3: mappings[0].max_revisions") {
+		t.Errorf("expected error with the file and the line, got %v", err)
+	}
+}
@@ -0,0 +1,519 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/alex123012/annotations-exporter/pkg/apiresources"
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// FieldError describes the invalid configuration field and its position in the source file.
+type FieldError struct {
+	File  string
+	Line  int
+	Field string
+	Err   string
+}
+
+func (e *FieldError) Error() string {
+	switch {
+	case e.Line > 0 && e.File != "":
+		return fmt.Sprintf("%s:%d: %s: %s", e.File, e.Line, e.Field, e.Err)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Field, e.Err)
+}
+
+// ValidationError contains all errors found during the configuration validation.
+type ValidationError []*FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return "invalid config:\n  " + strings.Join(messages, "\n  ")
+}
+
+// Validate fills mapping fields omitted in the file with the top-level values and checks the whole configuration.
+func (c *Config) Validate() error {
+	var errs ValidationError
+	addErr := func(path []interface{}, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{
+			File:  c.path,
+			Line:  c.line(path...),
+			Field: fieldPath(path...),
+			Err:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	if err := validateNamespaces(c.Namespaces); err != nil {
+		addErr([]interface{}{"namespaces"}, "%v", err)
+	}
+	if _, err := labels.Parse(c.NamespaceSelector); err != nil {
+		addErr([]interface{}{"namespace_selector"}, "%v", err)
+	}
+	for i, namespace := range c.ExcludeNamespaces {
+		if err := validatePattern(namespace); err != nil {
+			addErr([]interface{}{"exclude_namespaces", i}, "%v", err)
+		}
+	}
+	for i, resource := range c.Resources {
+		for _, err := range validateResource(resource) {
+			addErr([]interface{}{"resources", i}, "%v", err)
+		}
+	}
+	for i, resource := range c.ExcludeResources {
+		if _, err := apiresources.ParseSelector(resource); err != nil {
+			addErr([]interface{}{"exclude_resources", i}, "%v", err)
+		}
+	}
+	switch c.Persistence.Backend {
+	case "":
+	case PersistenceFile:
+		if c.Persistence.Path == "" {
+			addErr([]interface{}{"persistence", "path"}, "is required for %s backend", PersistenceFile)
+		}
+	case PersistenceConfigMap:
+		if c.Persistence.Namespace == "" {
+			addErr([]interface{}{"persistence", "namespace"}, "is required for %s backend", PersistenceConfigMap)
+		}
+		if c.Persistence.Name == "" {
+			addErr([]interface{}{"persistence", "name"}, "is required for %s backend", PersistenceConfigMap)
+		}
+	default:
+		addErr([]interface{}{"persistence", "backend"}, "unknown backend %q, must be one of %s, %s",
+			c.Persistence.Backend, PersistenceFile, PersistenceConfigMap)
+	}
+	if c.Persistence.Debounce < 0 {
+		addErr([]interface{}{"persistence", "debounce"}, "must not be negative")
+	}
+	if c.DiscoveryInterval < 0 {
+		addErr([]interface{}{"discovery_interval"}, "must not be negative")
+	}
+	if c.ReconcileInterval < 0 {
+		addErr([]interface{}{"reconcile_interval"}, "must not be negative")
+	}
+	if c.MaxRevisions < 0 {
+		addErr([]interface{}{"max_revisions"}, "must not be negative")
+	}
+	if c.MaxSeries < 0 {
+		addErr([]interface{}{"max_series"}, "must not be negative")
+	}
+	validateLimits(c.Limits, func(path ...interface{}) []interface{} {
+		return append([]interface{}{"limits"}, path...)
+	}, addErr)
+
+	if len(c.Mappings) == 0 {
+		addErr([]interface{}{"mappings"}, "at least one mapping is required")
+	}
+
+	names := make(map[string]int, len(c.Mappings))
+	for i := range c.Mappings {
+		mapping := &c.Mappings[i]
+		// Inherited top-level resources and namespaces are already validated.
+		inheritedResources, inheritedNamespaces := len(mapping.Resources) == 0, len(mapping.Namespaces) == 0
+		inheritedNamespaceSelector := mapping.NamespaceSelector == ""
+		inheritedExcludeNamespaces := len(mapping.ExcludeNamespaces) == 0
+		inheritedExcludeResources := len(mapping.ExcludeResources) == 0
+		// Only own limits are validated, inherited limits are validated as top-level ones.
+		ownLimits := mapping.Limits
+		c.completeMapping(mapping)
+
+		field := func(path ...interface{}) []interface{} {
+			return append([]interface{}{"mappings", i}, path...)
+		}
+
+		switch {
+		case mapping.Name == "":
+			addErr(field("name"), "must not be empty")
+		case !model.IsValidMetricName(model.LabelValue(mapping.Name)):
+			addErr(field("name"), "%q is not a valid prometheus metric name", mapping.Name)
+		default:
+			if j, ok := names[mapping.Name]; ok {
+				addErr(field("name"), "duplicate metric name %q, already declared in mappings[%d]", mapping.Name, j)
+			}
+			names[mapping.Name] = i
+		}
+
+		if len(mapping.Resources) == 0 {
+			addErr(field("resources"), "at least one resource is required")
+		}
+		for j, resource := range mapping.Resources {
+			if inheritedResources {
+				break
+			}
+			for _, err := range validateResource(resource) {
+				addErr(field("resources", j), "%v", err)
+			}
+		}
+
+		for j, resource := range mapping.ExcludeResources {
+			if inheritedExcludeResources {
+				break
+			}
+			if _, err := apiresources.ParseSelector(resource); err != nil {
+				addErr(field("exclude_resources", j), "%v", err)
+			}
+		}
+
+		if err := validateNamespaces(mapping.Namespaces); err != nil && !inheritedNamespaces {
+			addErr(field("namespaces"), "%v", err)
+		}
+		if _, err := labels.Parse(mapping.NamespaceSelector); err != nil && !inheritedNamespaceSelector {
+			addErr(field("namespace_selector"), "%v", err)
+		}
+		for j, namespace := range mapping.ExcludeNamespaces {
+			if inheritedExcludeNamespaces {
+				break
+			}
+			if err := validatePattern(namespace); err != nil {
+				addErr(field("exclude_namespaces", j), "%v", err)
+			}
+		}
+		for j, kind := range mapping.Kinds {
+			if kind == "" {
+				addErr(field("kinds", j), "must not be empty")
+			}
+		}
+
+		if mapping.MaxRevisions < 1 {
+			addErr(field("max_revisions"), "must be greater than zero")
+		}
+		validateLimits(ownLimits, func(path ...interface{}) []interface{} {
+			return field(append([]interface{}{"limits"}, path...)...)
+		}, addErr)
+
+		validateKeys := func(name string, keys []string, allowPatterns bool) {
+			for j, key := range keys {
+				switch {
+				case key == "":
+					addErr(field(name, j), "must not be empty")
+				case !pattern.IsPattern(key):
+				case !allowPatterns:
+					addErr(field(name, j), "patterns are not allowed, got %q", key)
+				default:
+					if err := validatePattern(key); err != nil {
+						addErr(field(name, j), "%v", err)
+					}
+				}
+			}
+		}
+		validateKeys("reference_labels", mapping.ReferenceLabels, false)
+		validateKeys("reference_annotations", mapping.ReferenceAnnotations, false)
+		validateKeys("kube_labels", mapping.KubeLabels, true)
+		validateKeys("kube_annotations", mapping.KubeAnnotations, true)
+		validateKeys("exclude_keys", mapping.ExcludeKeys, true)
+
+		fieldNames := make(map[string]int, len(mapping.Fields))
+		for j, f := range mapping.Fields {
+			switch {
+			case f.Name == "":
+				addErr(field("fields", j, "name"), "must not be empty")
+			default:
+				if k, ok := fieldNames[f.Name]; ok {
+					addErr(field("fields", j, "name"), "duplicate field name %q, already declared in fields[%d]", f.Name, k)
+				}
+				fieldNames[f.Name] = j
+			}
+			if f.Path == "" {
+				addErr(field("fields", j, "path"), "must not be empty")
+			} else if _, err := collector.ParseFieldPath(f.Path); err != nil {
+				addErr(field("fields", j, "path"), "%v", err)
+			}
+			switch f.List {
+			case "", collector.FieldListJoin, collector.FieldListFirst:
+			case collector.FieldListAll:
+				if mapping.Mode != collector.ModeKeyValue {
+					addErr(field("fields", j, "list"), "%s can be used only with %s mode", f.List, collector.ModeKeyValue)
+				}
+			default:
+				addErr(field("fields", j, "list"), "unknown list mode %q, must be one of %s, %s, %s",
+					f.List, collector.FieldListJoin, collector.FieldListFirst, collector.FieldListAll)
+			}
+		}
+
+		if mapping.Prefix != nil && *mapping.Prefix != "" && !model.LabelName(*mapping.Prefix).IsValid() {
+			addErr(field("prefix"), "%q is not a valid prometheus label name prefix", *mapping.Prefix)
+		}
+		validateLabelNames := func(name string, labelNames map[string]string) {
+			keys := make([]string, 0, len(labelNames))
+			for key := range labelNames {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if labelName := labelNames[key]; !model.LabelName(labelName).IsValid() || strings.HasPrefix(labelName, model.ReservedLabelPrefix) {
+					addErr(field("label_names", name, key), "%q is not a valid prometheus label name", labelName)
+				}
+			}
+		}
+		validateLabelNames("labels", mapping.LabelNames.Labels)
+		validateLabelNames("annotations", mapping.LabelNames.Annotations)
+		validateLabelNames("fields", mapping.LabelNames.Fields)
+		for key := range mapping.LabelNames.Fields {
+			if _, ok := fieldNames[key]; !ok {
+				addErr(field("label_names", "fields", key), "unknown field %q", key)
+			}
+		}
+
+		for j, transform := range mapping.Transforms {
+			sources := 0
+			for _, source := range []string{transform.Label, transform.Annotation, transform.Field} {
+				if source != "" {
+					sources++
+				}
+			}
+			if sources != 1 {
+				addErr(field("transforms", j), "exactly one of label, annotation or field is required")
+			}
+			if _, ok := fieldNames[transform.Field]; transform.Field != "" && !ok {
+				addErr(field("transforms", j, "field"), "unknown field %q", transform.Field)
+			}
+			if len(transform.Steps) == 0 {
+				addErr(field("transforms", j, "steps"), "at least one step is required")
+			}
+			for k, step := range transform.Steps {
+				if err := collector.ValidateTransformStep(step); err != nil {
+					addErr(field("transforms", j, "steps", k), "%v", err)
+				}
+			}
+		}
+
+		if valueFrom := mapping.ValueFrom; valueFrom != nil {
+			sources := 0
+			for _, source := range []string{valueFrom.Label, valueFrom.Annotation, valueFrom.Field} {
+				if source != "" {
+					sources++
+				}
+			}
+			if sources != 1 {
+				addErr(field("value_from"), "exactly one of label, annotation or field is required")
+			}
+			if valueFrom.Field != "" {
+				if _, err := collector.ParseFieldPath(valueFrom.Field); err != nil {
+					addErr(field("value_from", "field"), "%v", err)
+				}
+			}
+			switch valueFrom.Type {
+			case "", collector.ValueTypeFloat, collector.ValueTypeTime, collector.ValueTypeDuration:
+			default:
+				addErr(field("value_from", "type"), "unknown value type %q, must be one of %s, %s, %s",
+					valueFrom.Type, collector.ValueTypeFloat, collector.ValueTypeTime, collector.ValueTypeDuration)
+			}
+			switch valueFrom.OnError {
+			case "", collector.OnErrorSkip, collector.OnErrorNaN, collector.OnErrorDefault:
+			default:
+				addErr(field("value_from", "on_error"), "unknown on_error %q, must be one of %s, %s, %s",
+					valueFrom.OnError, collector.OnErrorSkip, collector.OnErrorNaN, collector.OnErrorDefault)
+			}
+			if mapping.Mode == collector.ModeKeyValue {
+				addErr(field("value_from"), "can't be used with %s mode", collector.ModeKeyValue)
+			}
+		}
+
+		switch mapping.RevisionTimestamps {
+		case "", collector.RevisionTimestampsMetric:
+		case collector.RevisionTimestampsValue:
+			if mapping.ValueFrom != nil {
+				addErr(field("revision_timestamps"), "%s can't be used with value_from", mapping.RevisionTimestamps)
+			}
+		default:
+			addErr(field("revision_timestamps"), "unknown revision timestamps %q, must be one of %s, %s",
+				mapping.RevisionTimestamps, collector.RevisionTimestampsMetric, collector.RevisionTimestampsValue)
+		}
+		switch mapping.RevisionVersion {
+		case "", collector.RevisionVersionGeneration, collector.RevisionVersionResourceVersion:
+		default:
+			addErr(field("revision_version"), "unknown revision version %q, must be one of %s, %s",
+				mapping.RevisionVersion, collector.RevisionVersionGeneration, collector.RevisionVersionResourceVersion)
+		}
+		if mapping.Mode == collector.ModeKeyValue {
+			if mapping.RevisionTimestamps != "" {
+				addErr(field("revision_timestamps"), "can't be used with %s mode", collector.ModeKeyValue)
+			}
+			if mapping.RevisionVersion != "" {
+				addErr(field("revision_version"), "can't be used with %s mode", collector.ModeKeyValue)
+			}
+		}
+
+		switch mapping.Mode {
+		case "", collector.ModeRevisions:
+			if mapping.MaxValueLength != 0 {
+				addErr(field("max_value_length"), "can be used only with %s mode", collector.ModeKeyValue)
+			}
+		case collector.ModeKeyValue:
+			if len(mapping.KubeLabels)+len(mapping.KubeAnnotations)+len(mapping.Fields) == 0 {
+				addErr(field("mode"), "at least one of kube_labels or kube_annotations patterns or fields is required")
+			}
+			if mapping.MaxValueLength < 0 {
+				addErr(field("max_value_length"), "must not be negative")
+			}
+		default:
+			addErr(field("mode"), "unknown mode %q, must be one of %s, %s", mapping.Mode, collector.ModeRevisions, collector.ModeKeyValue)
+		}
+
+		if mapping.OnlyLabelsAndAnnotations {
+			if len(mapping.KubeResourceMeta) > 0 {
+				addErr(field("resource_meta"), "can't be used with only_labels_and_annotations")
+			}
+			if len(mapping.ReferenceLabels)+len(mapping.ReferenceAnnotations)+len(mapping.KubeLabels)+len(mapping.KubeAnnotations)+len(mapping.Fields) == 0 {
+				addErr(field("only_labels_and_annotations"), "at least one label, annotation or field is required")
+			}
+		} else if n := len(mapping.KubeResourceMeta); n != 0 && n != 4 {
+			addErr(field("resource_meta"), "must contain label names for api version, kind, namespace and name, got %d names", n)
+		}
+
+		if mapping.Aggregate {
+			if !mapping.OnlyLabelsAndAnnotations {
+				addErr(field("aggregate"), "can be used only with only_labels_and_annotations")
+			}
+			if mapping.Mode == collector.ModeKeyValue {
+				addErr(field("aggregate"), "can't be used with %s mode", collector.ModeKeyValue)
+			}
+			if mapping.ValueFrom != nil {
+				addErr(field("aggregate"), "can't be used with value_from")
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (c *Config) completeMapping(mapping *Mapping) {
+	if len(mapping.Resources) == 0 {
+		mapping.Resources = c.Resources
+	}
+	if len(mapping.ExcludeResources) == 0 {
+		mapping.ExcludeResources = c.ExcludeResources
+	}
+	if len(mapping.Namespaces) == 0 {
+		mapping.Namespaces = c.Namespaces
+	}
+	if mapping.NamespaceSelector == "" {
+		mapping.NamespaceSelector = c.NamespaceSelector
+	}
+	if len(mapping.ExcludeNamespaces) == 0 {
+		mapping.ExcludeNamespaces = c.ExcludeNamespaces
+	}
+	if mapping.MaxRevisions == 0 {
+		mapping.MaxRevisions = c.MaxRevisions
+	}
+	if mapping.Limits.MaxSeries == 0 {
+		mapping.Limits.MaxSeries = c.Limits.MaxSeries
+	}
+	if mapping.Limits.MaxValuesPerKey == 0 {
+		mapping.Limits.MaxValuesPerKey = c.Limits.MaxValuesPerKey
+	}
+	if mapping.Limits.Overflow == "" {
+		mapping.Limits.Overflow = c.Limits.Overflow
+	}
+}
+
+func validateLimits(limits collector.Limits, field func(path ...interface{}) []interface{},
+	addErr func(path []interface{}, format string, args ...interface{})) {
+	if limits.MaxSeries < 0 {
+		addErr(field("max_series"), "must not be negative")
+	}
+	if limits.MaxValuesPerKey < 0 {
+		addErr(field("max_values_per_key"), "must not be negative")
+	}
+	switch limits.Overflow {
+	case "", collector.OverflowDrop, collector.OverflowReplace, collector.OverflowHash:
+	default:
+		addErr(field("overflow"), "unknown overflow %q, must be one of %s, %s, %s",
+			limits.Overflow, collector.OverflowDrop, collector.OverflowReplace, collector.OverflowHash)
+	}
+}
+
+func validateResource(resource Resource) []error {
+	var errs []error
+	if _, err := apiresources.ParseSelector(resource.Resource); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := labels.Parse(resource.LabelSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid label selector: %w", err))
+	}
+	if _, err := fields.ParseSelector(resource.FieldSelector); err != nil {
+		errs = append(errs, fmt.Errorf("invalid field selector: %w", err))
+	}
+	return errs
+}
+
+func validatePattern(s string) error {
+	if s == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	_, err := pattern.Compile(s)
+	return err
+}
+
+func validateNamespaces(namespaces []string) error {
+	for _, namespace := range namespaces {
+		if namespace == "" && len(namespaces) > 1 {
+			return fmt.Errorf("can't use several namespaces with all ('') namespaces specified")
+		}
+	}
+	return nil
+}
+
+// line returns the line of the deepest node found by the path of mapping keys and sequence indexes.
+func (c *Config) line(path ...interface{}) int {
+	if c.root == nil || len(c.root.Content) == 0 {
+		return 0
+	}
+	node, line := c.root.Content[0], 0
+	for _, step := range path {
+		var next *yaml.Node
+		switch step := step.(type) {
+		case string:
+			if node.Kind != yaml.MappingNode {
+				return line
+			}
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == step {
+					line, next = node.Content[i].Line, node.Content[i+1]
+					break
+				}
+			}
+		case int:
+			if node.Kind != yaml.SequenceNode || step >= len(node.Content) {
+				return line
+			}
+			next = node.Content[step]
+			line = next.Line
+		}
+		if next == nil {
+			return line
+		}
+		node = next
+	}
+	return line
+}
+
+func fieldPath(path ...interface{}) string {
+	var b strings.Builder
+	for _, step := range path {
+		switch step := step.(type) {
+		case string:
+			if b.Len() > 0 {
+				b.WriteByte('.')
+			}
+			b.WriteString(step)
+		case int:
+			fmt.Fprintf(&b, "[%d]", step)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,143 @@
+package kube
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/tools/cache"
+)
+
+// metadataKeys are top-level keys of objects cached in any mode.
+var metadataKeys = map[string]struct{}{"apiVersion": {}, "kind": {}, "metadata": {}}
+
+// objectContent is the content of objects cached by informers of the resource. Objects are always cached
+// with apiVersion, kind and metadata except managed fields, which are large and never read.
+type objectContent struct {
+	// whole is set if whole objects are cached.
+	whole bool
+	// keys are sorted top-level keys of objects cached besides the metadata joined by commas.
+	keys string
+}
+
+// metadataOnly checks that only metadata of objects is needed, so it is watched with the metadata client.
+func (o objectContent) metadataOnly() bool {
+	return !o.whole && o.keys == ""
+}
+
+func (o objectContent) String() string {
+	switch {
+	case o.whole:
+		return "whole objects"
+	case o.metadataOnly():
+		return "metadata only"
+	}
+	return "metadata and " + o.keys
+}
+
+// content returns the content of objects of the resource read by bindings fed by it. Must be called with the lock held.
+func (c *InformerController) content(resource Resource) objectContent {
+	unique := make(map[string]struct{})
+	for _, binding := range c.bindings[resource] {
+		if binding.WholeObject {
+			return objectContent{whole: true}
+		}
+		for _, key := range binding.ObjectKeys {
+			if _, ok := metadataKeys[key]; !ok {
+				unique[key] = struct{}{}
+			}
+		}
+	}
+	keys := make([]string, 0, len(unique))
+	for key := range unique {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return objectContent{keys: strings.Join(keys, ",")}
+}
+
+// listWatch returns list and watch functions of the resource in the namespace. Only metadata of objects
+// is listed and watched if other keys are not needed.
+func (c *InformerController) listWatch(namespace string, resource Resource, content objectContent) (*cache.ListWatch, runtime.Object) {
+	if content.metadataOnly() {
+		return metadataListWatch(c.metadataClient, namespace, resource), &metav1.PartialObjectMetadata{}
+	}
+	return dynamicListWatch(c.client, namespace, resource), &unstructured.Unstructured{}
+}
+
+func dynamicListWatch(client dynamic.Interface, namespace string, resource Resource) *cache.ListWatch {
+	resourceClient := client.Resource(resource.GroupVersionResource).Namespace(namespace)
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return resourceClient.List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return resourceClient.Watch(context.TODO(), options)
+		},
+	}
+}
+
+func metadataListWatch(client metadata.Interface, namespace string, resource Resource) *cache.ListWatch {
+	resourceClient := client.Resource(resource.GroupVersionResource).Namespace(namespace)
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			return resourceClient.List(context.TODO(), options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return resourceClient.Watch(context.TODO(), options)
+		},
+	}
+}
+
+// transform returns the informer transform function, which converts objects of the resource to unstructured
+// objects with the content only, so handlers and the cache get the same objects in both modes.
+func (o objectContent) transform(resource Resource) cache.TransformFunc {
+	keys := make(map[string]struct{})
+	for key := range metadataKeys {
+		keys[key] = struct{}{}
+	}
+	for _, key := range strings.Split(o.keys, ",") {
+		keys[key] = struct{}{}
+	}
+	apiVersion := resource.GroupVersion().String()
+
+	return func(obj interface{}) (interface{}, error) {
+		switch object := obj.(type) {
+		case *metav1.PartialObjectMetadata:
+			// Objects listed by the metadata client have the PartialObjectMetadata kind.
+			object.ManagedFields = nil
+			objectMeta, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&object.ObjectMeta)
+			if err != nil {
+				return nil, err
+			}
+			return &unstructured.Unstructured{Object: map[string]interface{}{
+				"apiVersion": apiVersion,
+				"kind":       resource.Kind,
+				"metadata":   objectMeta,
+			}}, nil
+		case *unstructured.Unstructured:
+			// Objects from the api are not shared yet. Objects resynced from the cache are already transformed,
+			// so they are not changed while handlers may read them.
+			if _, found, _ := unstructured.NestedFieldNoCopy(object.Object, "metadata", "managedFields"); found {
+				unstructured.RemoveNestedField(object.Object, "metadata", "managedFields")
+			}
+			if o.whole {
+				return object, nil
+			}
+			for key := range object.Object {
+				if _, ok := keys[key]; !ok {
+					delete(object.Object, key)
+				}
+			}
+			return object, nil
+		}
+		// Tombstones of deleted objects contain already transformed objects from the cache.
+		return obj, nil
+	}
+}
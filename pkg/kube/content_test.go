@@ -0,0 +1,219 @@
+package kube
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sruntime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+var testPodsResource = Resource{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "pods"}, Kind: "Pod"}
+
+// testPod returns the pod with the managed fields, the spec and the status like the one served by the kubernetes api.
+func testPod(i int) *unstructured.Unstructured {
+	name := fmt.Sprintf("pod-%d", i)
+	managedFields := make([]interface{}, 0, 3)
+	for _, manager := range []string{"kube-controller-manager", "kubelet", "kubectl"} {
+		managedFields = append(managedFields, map[string]interface{}{
+			"manager":    manager,
+			"operation":  "Update",
+			"apiVersion": "v1",
+			"time":       "2022-10-01T00:00:00Z",
+			"fieldsType": "FieldsV1",
+			"fieldsV1": map[string]interface{}{
+				"f:metadata": map[string]interface{}{"f:labels": map[string]interface{}{"f:app": map[string]interface{}{}}},
+				"f:spec": map[string]interface{}{"f:containers": map[string]interface{}{
+					`k:{"name":"app"}`: map[string]interface{}{"f:image": map[string]interface{}{}, "f:resources": map[string]interface{}{}},
+				}},
+				"f:status": map[string]interface{}{"f:conditions": map[string]interface{}{}, "f:phase": map[string]interface{}{}},
+			},
+		})
+	}
+	containers := make([]interface{}, 0, 2)
+	for _, container := range []string{"app", "sidecar"} {
+		containers = append(containers, map[string]interface{}{
+			"name":  container,
+			"image": "registry.example.com/" + container + ":v1.2.3",
+			"env": []interface{}{
+				map[string]interface{}{"name": "LOG_LEVEL", "value": "info"},
+				map[string]interface{}{"name": "POD_NAME", "valueFrom": map[string]interface{}{
+					"fieldRef": map[string]interface{}{"fieldPath": "metadata.name"}}},
+			},
+			"resources": map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "100m", "memory": "128Mi"},
+				"limits":   map[string]interface{}{"memory": "256Mi"},
+			},
+		})
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]interface{}{
+			"name":              name,
+			"namespace":         "default",
+			"uid":               fmt.Sprintf("00000000-0000-0000-0000-%012d", i),
+			"resourceVersion":   "12345",
+			"creationTimestamp": "2022-10-01T00:00:00Z",
+			"labels":            map[string]interface{}{"app": "app", "pod-template-hash": "7d9f8c6b5"},
+			"annotations":       map[string]interface{}{"werf.io/version": "v1.2.3"},
+			"managedFields":     managedFields,
+		},
+		"spec": map[string]interface{}{
+			"containers":         containers,
+			"nodeName":           "node-1",
+			"serviceAccountName": "default",
+		},
+		"status": map[string]interface{}{
+			"phase": "Running",
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Ready", "status": "True"},
+				map[string]interface{}{"type": "ContainersReady", "status": "True"},
+			},
+		},
+	}}
+}
+
+// testPodMetadata returns the pod as it is served by the metadata client.
+func testPodMetadata(i int) *metav1.PartialObjectMetadata {
+	object := &metav1.PartialObjectMetadata{TypeMeta: metav1.TypeMeta{APIVersion: "meta.k8s.io/v1", Kind: "PartialObjectMetadata"}}
+	if err := k8sruntime.DefaultUnstructuredConverter.FromUnstructured(testPod(i).Object["metadata"].(map[string]interface{}),
+		&object.ObjectMeta); err != nil {
+		panic(err)
+	}
+	return object
+}
+
+func TestObjectContentTransform(t *testing.T) {
+	transformed := testPod(0)
+	transformed.SetManagedFields(nil)
+	metadataOnly := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": transformed.Object["apiVersion"],
+		"kind":       transformed.Object["kind"],
+		"metadata":   transformed.Object["metadata"],
+	}}
+	specOnly := metadataOnly.DeepCopy()
+	specOnly.Object["spec"] = transformed.Object["spec"]
+
+	tests := []struct {
+		name     string
+		content  objectContent
+		obj      interface{}
+		expected interface{}
+	}{
+		{name: "whole object", content: objectContent{whole: true}, obj: testPod(0), expected: transformed},
+		{name: "keys", content: objectContent{keys: "spec"}, obj: testPod(0), expected: specOnly},
+		{name: "unknown keys", content: objectContent{keys: "data"}, obj: testPod(0), expected: metadataOnly},
+		{name: "metadata only object", content: objectContent{}, obj: testPod(0), expected: metadataOnly},
+		{name: "partial object metadata", content: objectContent{}, obj: testPodMetadata(0), expected: metadataOnly},
+		{name: "transformed object", content: objectContent{keys: "spec"}, obj: specOnly.DeepCopy(), expected: specOnly},
+		{
+			name:     "tombstone",
+			content:  objectContent{},
+			obj:      cache.DeletedFinalStateUnknown{Key: "default/pod-0", Obj: metadataOnly},
+			expected: cache.DeletedFinalStateUnknown{Key: "default/pod-0", Obj: metadataOnly},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := tt.content.transform(testPodsResource)(tt.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestObjectContentTransformCoreAndGroupKinds(t *testing.T) {
+	deployments := Resource{GroupVersionResource: schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		Kind: "Deployment"}
+	for resource, apiVersion := range map[Resource]string{testPodsResource: "v1", deployments: "apps/v1"} {
+		result, err := objectContent{}.transform(resource)(testPodMetadata(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		object := result.(*unstructured.Unstructured)
+		if object.GetAPIVersion() != apiVersion || object.GetKind() != resource.Kind {
+			t.Errorf("expected %s %s, got %s %s", apiVersion, resource.Kind, object.GetAPIVersion(), object.GetKind())
+		}
+		sample := ResourceToSample(object)
+		if !reflect.DeepEqual(sample.ResourceMeta, []string{apiVersion, resource.Kind, "default", "pod-0"}) {
+			t.Errorf("unexpected resource meta %q", sample.ResourceMeta)
+		}
+		if sample.ResourceAnnotations["werf.io/version"] != "v1.2.3" || sample.ResourceLabels["app"] != "app" {
+			t.Errorf("unexpected labels %v and annotations %v", sample.ResourceLabels, sample.ResourceAnnotations)
+		}
+	}
+}
+
+const benchmarkObjects = 1000
+
+// BenchmarkInformerCache compares memory used by cached objects of every content. The cached-B/object metric is
+// the heap retained by the cache after objects are transformed and stored.
+func BenchmarkInformerCache(b *testing.B) {
+	noTransform := func(obj interface{}) (interface{}, error) {
+		return obj, nil
+	}
+	benchmarks := []struct {
+		name      string
+		transform cache.TransformFunc
+		object    func(i int) interface{}
+	}{
+		{name: "full objects", transform: noTransform, object: func(i int) interface{} { return testPod(i) }},
+		{name: "whole objects", transform: objectContent{whole: true}.transform(testPodsResource),
+			object: func(i int) interface{} { return testPod(i) }},
+		{name: "status only", transform: objectContent{keys: "status"}.transform(testPodsResource),
+			object: func(i int) interface{} { return testPod(i) }},
+		{name: "metadata only", transform: objectContent{}.transform(testPodsResource),
+			object: func(i int) interface{} { return testPodMetadata(i) }},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				var before, after runtime.MemStats
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+				objects := make([]interface{}, benchmarkObjects)
+				for j := range objects {
+					objects[j] = bm.object(j)
+				}
+				b.StartTimer()
+
+				store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+				for j, obj := range objects {
+					transformed, err := bm.transform(obj)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if err := store.Add(transformed); err != nil {
+						b.Fatal(err)
+					}
+					objects[j] = nil
+				}
+
+				b.StopTimer()
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				if after.HeapAlloc > before.HeapAlloc {
+					retained += after.HeapAlloc - before.HeapAlloc
+				}
+				runtime.KeepAlive(store)
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(retained)/float64(b.N)/benchmarkObjects, "cached-B/object")
+		})
+	}
+}
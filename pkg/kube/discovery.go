@@ -0,0 +1,142 @@
+package kube
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/alex123012/annotations-exporter/pkg/apiresources"
+	"k8s.io/client-go/rest"
+)
+
+// ResourceRequest is the configured resource string with selectors, that is resolved with the discovery data.
+type ResourceRequest struct {
+	Resource      string
+	LabelSelector string
+	FieldSelector string
+}
+
+// ResourceRequests are resources requested for the metric and resources excluded from them.
+type ResourceRequests struct {
+	Resources []ResourceRequest
+	Exclude   []string
+}
+
+// ResolveResources resolves requested resources with resources served by the kubernetes api except excluded ones.
+// Requests that select no resources are returned as pending.
+func ResolveResources(apiResources *apiresources.APIResources, requests ResourceRequests) ([]Resource, []string, error) {
+	var resolved []Resource
+	var pending []string
+	for _, request := range requests.Resources {
+		gvrs, err := apiResources.Resolve(request.Resource)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(gvrs) == 0 {
+			pending = append(pending, request.Resource)
+			continue
+		}
+		if gvrs, err = apiResources.Exclude(gvrs, requests.Exclude); err != nil {
+			return nil, nil, err
+		}
+		for _, gvr := range gvrs {
+			resource := Resource{
+				GroupVersionResource: gvr,
+				Kind:                 apiResources.Kind(gvr),
+				LabelSelector:        request.LabelSelector,
+				FieldSelector:        request.FieldSelector,
+			}
+			if !containsResource(resolved, resource) {
+				resolved = append(resolved, resource)
+			}
+		}
+	}
+	return resolved, pending, nil
+}
+
+// SetPendingResources updates the pending resources metric of the metric name.
+func SetPendingResources(metricName string, requests ResourceRequests, pending []string) {
+	pendingSet := make(map[string]struct{}, len(pending))
+	for _, resource := range pending {
+		pendingSet[resource] = struct{}{}
+	}
+	for _, request := range requests.Resources {
+		if _, ok := pendingSet[request.Resource]; ok {
+			PendingResources.WithLabelValues(metricName, request.Resource).Set(1)
+			continue
+		}
+		PendingResources.DeleteLabelValues(metricName, request.Resource)
+	}
+}
+
+// RefreshResources periodically refreshes the discovery data and starts informers for requested resources
+// when they are served by the kubernetes api, e.g. after the CRD is installed, and stops informers for removed ones.
+// Requests are keyed by the metric name.
+func (c *InformerController) RefreshResources(ctx context.Context, config *rest.Config, interval time.Duration,
+	requests map[string]ResourceRequests) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		apiResources, err := apiresources.GetAllApiResources(config)
+		if err != nil {
+			log.Printf("refresh api resources: %v", err)
+			continue
+		}
+		for metricName, metricRequests := range requests {
+			resolved, pending, err := ResolveResources(apiResources, metricRequests)
+			if err != nil {
+				log.Printf("resolve resources for metric %s: %v", metricName, err)
+				continue
+			}
+			SetPendingResources(metricName, metricRequests, pending)
+
+			current := c.resources(metricName)
+			if sameResources(current, resolved) {
+				continue
+			}
+			for _, resource := range resolved {
+				if !containsResource(current, resource) {
+					log.Printf("Starting watching for resource %s for metric %s", resource.String(), metricName)
+				}
+			}
+			for _, resource := range current {
+				if !containsResource(resolved, resource) {
+					log.Printf("Stopping watching for removed resource %s for metric %s", resource.String(), metricName)
+				}
+			}
+			c.SetResources(ctx, metricName, resolved)
+		}
+	}
+}
+
+// resources returns resources watched for the metric name.
+func (c *InformerController) resources(metricName string) []Resource {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, binding := range c.all {
+		if binding.MetricName == metricName {
+			return binding.Resources
+		}
+	}
+	return nil
+}
+
+func sameResources(a, b []Resource) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package kube
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/rest"
+)
+
+const listPageSize = 500
+
+// ExpandMappingKeys replaces label and annotation patterns of the mapping with the matching keys of objects
+// that feed the binding. Keys are expanded once on startup and sorted, so the metric labels are stable and don't
+// depend on the order of objects. Keys appeared after startup are not exported until the exporter is restarted.
+// Mappings in key value mode use patterns as is to filter keys, so they are not expanded.
+func ExpandMappingKeys(ctx context.Context, config *rest.Config, mapping collector.Mapping, binding Binding) (collector.Mapping, error) {
+	if mapping.Mode == collector.ModeKeyValue || !hasPatterns(mapping.KubeLabels) && !hasPatterns(mapping.KubeAnnotations) {
+		return mapping, nil
+	}
+	client, err := metadata.NewForConfig(config)
+	if err != nil {
+		return mapping, err
+	}
+	return expandMappingKeys(ctx, client, mapping, binding)
+}
+
+// expandMappingKeys lists metadata of objects of the binding to expand patterns of the mapping. Resources that
+// can't be listed are skipped like failed informers, so their keys are not expanded.
+func expandMappingKeys(ctx context.Context, client metadata.Interface, mapping collector.Mapping, binding Binding) (collector.Mapping, error) {
+	excludeKeys, err := pattern.CompileList(mapping.ExcludeKeys)
+	if err != nil {
+		return mapping, err
+	}
+
+	selected := selectedNamespaces(ctx, client, &binding)
+
+	labelKeys, annotationKeys := make(map[string]struct{}), make(map[string]struct{})
+	for _, resource := range binding.Resources {
+		for _, namespace := range binding.Namespaces {
+			err := listObjects(ctx, client, resource, namespace, func(obj *unstructured.Unstructured) {
+				if !binding.matches(obj) {
+					return
+				}
+				if _, ok := selected[obj.GetNamespace()]; selected != nil && !ok {
+					return
+				}
+				for key := range obj.GetLabels() {
+					labelKeys[key] = struct{}{}
+				}
+				for key := range obj.GetAnnotations() {
+					annotationKeys[key] = struct{}{}
+				}
+			})
+			if err != nil {
+				listFailed(namespace, resource, err)
+				log.Printf("expand keys for metric %s: failed to list %s, its keys are skipped: %v", mapping.Name, resource.String(), err)
+			}
+		}
+	}
+
+	if mapping.KubeLabels, err = expandKeys(mapping.Name, mapping.KubeLabels, labelKeys, excludeKeys); err != nil {
+		return mapping, err
+	}
+	if mapping.KubeAnnotations, err = expandKeys(mapping.Name, mapping.KubeAnnotations, annotationKeys, excludeKeys); err != nil {
+		return mapping, err
+	}
+	return mapping, nil
+}
+
+// selectedNamespaces returns namespaces selected by the namespace selector of the binding, or nil if the binding
+// has no selector. No namespaces are selected if they can't be listed, like when the namespace informer fails.
+func selectedNamespaces(ctx context.Context, client metadata.Interface, binding *Binding) map[string]struct{} {
+	if binding.NamespaceSelector == nil {
+		return nil
+	}
+	selected := make(map[string]struct{})
+	resource := Resource{GroupVersionResource: namespacesResource, Kind: "Namespace"}
+	err := listObjects(ctx, client, resource, v1.NamespaceAll, func(namespace *unstructured.Unstructured) {
+		if binding.selectsNamespace(namespace) {
+			selected[namespace.GetName()] = struct{}{}
+		}
+	})
+	if err != nil {
+		listFailed(v1.NamespaceAll, resource, err)
+		log.Printf("expand keys for metric %s: failed to list namespaces, keys are not expanded: %v", binding.MetricName, err)
+		return map[string]struct{}{}
+	}
+	return selected
+}
+
+// listFailed counts the failed list request of the resource in the namespace like failed requests of informers.
+func listFailed(namespace string, resource Resource, err error) {
+	reason, ok := classifyWatchError(err)
+	if !ok {
+		reason = WatchErrorTransient
+	}
+	watchErrors.WithLabelValues(namespace, resource.Group, resource.Version, resource.Resource, reason).Inc()
+}
+
+func hasPatterns(keys []string) bool {
+	for _, key := range keys {
+		if pattern.IsPattern(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// expandKeys replaces patterns with sorted matching keys except excluded ones and removes duplicates keeping
+// the first occurrence.
+func expandKeys(metricName string, keys []string, found map[string]struct{}, excludeKeys pattern.List) ([]string, error) {
+	sortedFound := make([]string, 0, len(found))
+	for key := range found {
+		sortedFound = append(sortedFound, key)
+	}
+	sort.Strings(sortedFound)
+
+	result := make([]string, 0, len(keys))
+	seen := make(map[string]struct{}, len(keys))
+	add := func(key string) {
+		if _, ok := seen[key]; !ok {
+			seen[key] = struct{}{}
+			result = append(result, key)
+		}
+	}
+
+	for _, key := range keys {
+		if !pattern.IsPattern(key) {
+			add(key)
+			continue
+		}
+
+		p, err := pattern.Compile(key)
+		if err != nil {
+			return nil, err
+		}
+		matched := 0
+		for _, foundKey := range sortedFound {
+			if p.Match(foundKey) && !excludeKeys.MatchAny(foundKey) {
+				add(foundKey)
+				matched++
+			}
+		}
+		if matched == 0 {
+			log.Printf("no keys found for pattern %q of metric %s", key, metricName)
+			continue
+		}
+		log.Printf("pattern %q of metric %s expanded to %d keys", key, metricName, matched)
+	}
+	return result, nil
+}
+
+// listObjects lists metadata of objects of the resource in the namespace by pages. Objects are converted
+// like in informers, so the handler gets unstructured objects with the kind of the resource.
+func listObjects(ctx context.Context, client metadata.Interface, resource Resource, namespace string,
+	handler func(obj *unstructured.Unstructured)) error {
+	transform := objectContent{}.transform(resource)
+	opts := metav1.ListOptions{Limit: listPageSize}
+	resource.tweakListOptions(&opts)
+	for {
+		list, err := client.Resource(resource.GroupVersionResource).Namespace(namespace).List(ctx, opts)
+		if err != nil {
+			return err
+		}
+		for i := range list.Items {
+			obj, err := transform(&list.Items[i])
+			if err != nil {
+				return err
+			}
+			handler(obj.(*unstructured.Unstructured))
+		}
+		if opts.Continue = list.GetContinue(); opts.Continue == "" {
+			return nil
+		}
+	}
+}
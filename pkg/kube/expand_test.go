@@ -0,0 +1,197 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8slabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/metadata/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestExpandKeys(t *testing.T) {
+	found := map[string]struct{}{
+		"app":                         {},
+		"app.kubernetes.io/name":      {},
+		"app.kubernetes.io/version":   {},
+		"app.kubernetes.io/component": {},
+		"ci.werf.io/commit":           {},
+		"helm.sh/chart":               {},
+	}
+	tests := []struct {
+		name     string
+		keys     []string
+		exclude  []string
+		expected []string
+	}{
+		{
+			name:     "exact keys are kept even if not found",
+			keys:     []string{"team", "app"},
+			expected: []string{"team", "app"},
+		},
+		{
+			name:     "glob is expanded to sorted keys",
+			keys:     []string{"app.kubernetes.io/*"},
+			expected: []string{"app.kubernetes.io/component", "app.kubernetes.io/name", "app.kubernetes.io/version"},
+		},
+		{
+			name:     "regular expression is expanded to sorted keys",
+			keys:     []string{`~(werf|helm)\.`},
+			expected: []string{"ci.werf.io/commit", "helm.sh/chart"},
+		},
+		{
+			name:     "excluded keys are skipped",
+			keys:     []string{"app.kubernetes.io/*"},
+			exclude:  []string{"*/version", "~component$"},
+			expected: []string{"app.kubernetes.io/name"},
+		},
+		{
+			name:     "exact keys are not excluded",
+			keys:     []string{"app.kubernetes.io/version"},
+			exclude:  []string{"*/version"},
+			expected: []string{"app.kubernetes.io/version"},
+		},
+		{
+			name:     "duplicates keep the first occurrence",
+			keys:     []string{"app.kubernetes.io/name", "app*", "app"},
+			expected: []string{"app.kubernetes.io/name", "app", "app.kubernetes.io/component", "app.kubernetes.io/version"},
+		},
+		{
+			name:     "pattern without matches is dropped",
+			keys:     []string{"example.com/*", "app"},
+			expected: []string{"app"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exclude, err := pattern.CompileList(tt.exclude)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The result must not depend on the map iteration order.
+			for i := 0; i < 5; i++ {
+				result, err := expandKeys("test", tt.keys, found, exclude)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(result, tt.expected) {
+					t.Fatalf("expected %q, got %q", tt.expected, result)
+				}
+			}
+		})
+	}
+
+	if _, err := expandKeys("test", []string{"~("}, found, nil); err == nil {
+		t.Error("expected error for the invalid regular expression")
+	}
+}
+
+func testMetadata(apiVersion, kind, namespace, name string, labels, annotations map[string]string) *metav1.PartialObjectMetadata {
+	return &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: apiVersion, Kind: kind},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Labels:      labels,
+			Annotations: annotations,
+		},
+	}
+}
+
+func TestExpandMappingKeys(t *testing.T) {
+	secrets := Resource{GroupVersionResource: schema.GroupVersionResource{Version: "v1", Resource: "secrets"}, Kind: "Secret"}
+	newClient := func() *fake.FakeMetadataClient {
+		scheme := fake.NewTestScheme()
+		metav1.AddMetaToScheme(scheme)
+		client := fake.NewSimpleMetadataClient(scheme,
+			testMetadata("v1", "Namespace", "", "prod", map[string]string{"env": "prod"}, nil),
+			testMetadata("v1", "Namespace", "", "dev", map[string]string{"env": "dev"}, nil),
+			testMetadata("v1", "Pod", "prod", "a", map[string]string{"app.kubernetes.io/name": "a"},
+				map[string]string{"ci.werf.io/commit": "1"}),
+			testMetadata("v1", "Pod", "dev", "b", map[string]string{"app.kubernetes.io/version": "1"},
+				map[string]string{"ci.werf.io/pipeline": "2"}),
+		)
+		client.PrependReactor("list", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			return true, nil, apierrors.NewForbidden(schema.GroupResource{Resource: "secrets"}, "", nil)
+		})
+		return client
+	}
+	mapping := collector.Mapping{
+		Name:            "test",
+		KubeLabels:      []string{"app.kubernetes.io/*"},
+		KubeAnnotations: []string{"ci.werf.io/*"},
+	}
+
+	tests := []struct {
+		name                string
+		binding             Binding
+		expectedLabels      []string
+		expectedAnnotations []string
+	}{
+		{
+			name:                "all namespaces",
+			binding:             Binding{Resources: []Resource{testPodsResource}, Namespaces: []string{""}},
+			expectedLabels:      []string{"app.kubernetes.io/name", "app.kubernetes.io/version"},
+			expectedAnnotations: []string{"ci.werf.io/commit", "ci.werf.io/pipeline"},
+		},
+		{
+			name:                "explicit namespaces",
+			binding:             Binding{Resources: []Resource{testPodsResource}, Namespaces: []string{"dev"}},
+			expectedLabels:      []string{"app.kubernetes.io/version"},
+			expectedAnnotations: []string{"ci.werf.io/pipeline"},
+		},
+		{
+			name: "excluded namespaces",
+			binding: Binding{Resources: []Resource{testPodsResource}, Namespaces: []string{""},
+				ExcludeNamespaces: mustCompileList(t, "d*")},
+			expectedLabels:      []string{"app.kubernetes.io/name"},
+			expectedAnnotations: []string{"ci.werf.io/commit"},
+		},
+		{
+			name: "namespace selector",
+			binding: Binding{Resources: []Resource{testPodsResource}, Namespaces: []string{""},
+				NamespaceSelector: k8slabels.SelectorFromSet(k8slabels.Set{"env": "prod"})},
+			expectedLabels:      []string{"app.kubernetes.io/name"},
+			expectedAnnotations: []string{"ci.werf.io/commit"},
+		},
+		{
+			name:                "forbidden resources are skipped",
+			binding:             Binding{Resources: []Resource{secrets, testPodsResource}, Namespaces: []string{"prod"}},
+			expectedLabels:      []string{"app.kubernetes.io/name"},
+			expectedAnnotations: []string{"ci.werf.io/commit"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.binding.MetricName = mapping.Name
+			result, err := expandMappingKeys(context.Background(), newClient(), mapping, tt.binding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(result.KubeLabels, tt.expectedLabels) {
+				t.Errorf("expected labels %q, got %q", tt.expectedLabels, result.KubeLabels)
+			}
+			if !reflect.DeepEqual(result.KubeAnnotations, tt.expectedAnnotations) {
+				t.Errorf("expected annotations %q, got %q", tt.expectedAnnotations, result.KubeAnnotations)
+			}
+		})
+	}
+}
+
+func mustCompileList(t *testing.T, patterns ...string) pattern.List {
+	t.Helper()
+	list, err := pattern.CompileList(patterns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
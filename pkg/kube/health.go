@@ -0,0 +1,183 @@
+package kube
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// WatchErrorForbidden is the reason of errors when the exporter is not allowed to list or watch the resource.
+	WatchErrorForbidden = "forbidden"
+	// WatchErrorNotFound is the reason of errors when the resource is not served by the kubernetes api.
+	WatchErrorNotFound = "not_found"
+	// WatchErrorTransient is the reason of other errors, e.g. when the kubernetes api is not available.
+	WatchErrorTransient = "transient"
+	// WatchErrorSetup is the reason of errors when the informer of the resource can't be created, it is not retried
+	// until informers are synced again, e.g. when namespaces or resources are changed.
+	WatchErrorSetup = "setup"
+)
+
+// classifyWatchError returns the reason of the list or watch error, or false if the watch is just closed
+// and restarted by the informer.
+func classifyWatchError(err error) (string, bool) {
+	switch {
+	case errors.Is(err, io.EOF), apierrors.IsResourceExpired(err), apierrors.IsGone(err):
+		return "", false
+	case apierrors.IsForbidden(err), apierrors.IsUnauthorized(err):
+		return WatchErrorForbidden, true
+	case apierrors.IsNotFound(err), apierrors.IsMethodNotSupported(err):
+		return WatchErrorNotFound, true
+	}
+	return WatchErrorTransient, true
+}
+
+// ResourceHealth is the state of the informer of the resource in the namespace. The informer retries failed
+// list and watch requests with backoff, so the resource is healthy again after the successful request.
+type ResourceHealth struct {
+	Namespace string
+	Resource  Resource
+	// Reason is the reason of the last error, it is empty while the resource is healthy.
+	Reason string
+	Error  error
+	// Since is the time of the last health change.
+	Since time.Time
+}
+
+// Healthy checks that the last list or watch request of the resource succeeded.
+func (h ResourceHealth) Healthy() bool {
+	return h.Reason == ""
+}
+
+// Unavailable checks that requests of the resource fail until the cluster is changed, e.g. RBAC is granted
+// or the resource is installed. Informers of unavailable resources are not waited to be synced.
+func (h ResourceHealth) Unavailable() bool {
+	switch h.Reason {
+	case WatchErrorForbidden, WatchErrorNotFound, WatchErrorSetup:
+		return true
+	}
+	return false
+}
+
+// resourceHealth tracks the health of the informer and exports it as metrics.
+type resourceHealth struct {
+	mu    sync.Mutex
+	state ResourceHealth
+}
+
+func newResourceHealth(namespace string, resource Resource) *resourceHealth {
+	h := &resourceHealth{state: ResourceHealth{Namespace: namespace, Resource: resource, Since: time.Now()}}
+	resourceHealthy.With(h.labels()).Set(1)
+	return h
+}
+
+func (h *resourceHealth) labels() map[string]string {
+	return map[string]string{
+		"namespace":      h.state.Namespace,
+		"group":          h.state.Resource.Group,
+		"version":        h.state.Resource.Version,
+		"resource":       h.state.Resource.Resource,
+		"label_selector": h.state.Resource.LabelSelector,
+		"field_selector": h.state.Resource.FieldSelector,
+	}
+}
+
+// failed handles the list or watch error.
+func (h *resourceHealth) failed(err error) {
+	reason, ok := classifyWatchError(err)
+	if !ok {
+		return
+	}
+	h.failedWith(reason, err)
+}
+
+// failedWith sets the reason of the error.
+func (h *resourceHealth) failedWith(reason string, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	watchErrors.WithLabelValues(h.state.Namespace, h.state.Resource.Group, h.state.Resource.Version,
+		h.state.Resource.Resource, reason).Inc()
+	if h.state.Reason != reason {
+		h.state.Since = time.Now()
+	}
+	h.state.Reason, h.state.Error = reason, err
+	resourceHealthy.With(h.labels()).Set(0)
+}
+
+// succeeded handles the successful list or watch request.
+func (h *resourceHealth) succeeded() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.state.Reason == "" {
+		return
+	}
+	h.state = ResourceHealth{Namespace: h.state.Namespace, Resource: h.state.Resource, Since: time.Now()}
+	resourceHealthy.With(h.labels()).Set(1)
+}
+
+func (h *resourceHealth) get() ResourceHealth {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.state
+}
+
+// stop removes health metrics of the stopped informer.
+func (h *resourceHealth) stop() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	resourceHealthy.Delete(h.labels())
+}
+
+// Health returns health states of running informers and informers failed to start sorted by namespaces and resources.
+func (c *InformerController) Health() []ResourceHealth {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make([]ResourceHealth, 0, len(c.informers)+len(c.failed))
+	for _, running := range c.informers {
+		result = append(result, running.health.get())
+	}
+	for _, health := range c.failed {
+		result = append(result, health.get())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Resource.String() < result[j].Resource.String()
+	})
+	return result
+}
+
+// newListWatch wraps list and watch functions of the resource to apply the resource selectors and to mark
+// the resource healthy on successful requests.
+func newListWatch(lw *cache.ListWatch, resource Resource, health *resourceHealth) *cache.ListWatch {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			resource.tweakListOptions(&options)
+			list, err := lw.List(options)
+			if err == nil {
+				health.succeeded()
+			}
+			return list, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			resource.tweakListOptions(&options)
+			w, err := lw.Watch(options)
+			if err == nil {
+				health.succeeded()
+			}
+			return w, err
+		},
+	}
+}
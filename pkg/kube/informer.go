@@ -3,106 +3,504 @@ package kube
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"log"
 
 	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/alex123012/annotations-exporter/pkg/pattern"
+	"github.com/prometheus/client_golang/prometheus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/metadata"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
 )
 
 var (
 	ExporterMetricName = "kube_annotations_exporter"
+	ExporterMetricHelp = "Expose Kubernetes annotations and lables from kubernetes objects"
 )
 
+// Resource is the Kubernetes resource watched with optional label and field selectors. Resources with different
+// selectors are watched by different informers.
+type Resource struct {
+	schema.GroupVersionResource
+	// Kind is the kind of objects of the resource from the discovery data. Objects listed by the metadata client
+	// don't have their kind set, so it is set by the informer.
+	Kind          string
+	LabelSelector string
+	FieldSelector string
+}
+
+func (r Resource) String() string {
+	result := r.GroupVersionResource.String()
+	if r.LabelSelector != "" {
+		result += fmt.Sprintf(", labels %q", r.LabelSelector)
+	}
+	if r.FieldSelector != "" {
+		result += fmt.Sprintf(", fields %q", r.FieldSelector)
+	}
+	return result
+}
+
+// tweakListOptions applies the resource selectors to list and watch requests.
+func (r Resource) tweakListOptions(options *metav1.ListOptions) {
+	options.LabelSelector = r.LabelSelector
+	options.FieldSelector = r.FieldSelector
+}
+
+// Binding describes the metric fed by samples of the resources from the namespaces.
+// If kinds are specified, only objects of these kinds are stored.
+// If the namespace selector is specified, only namespaces with matching labels are watched.
+// Objects from namespaces matching exclude patterns are never stored.
+type Binding struct {
+	MetricName        string
+	Resources         []Resource
+	Namespaces        []string
+	NamespaceSelector labels.Selector
+	ExcludeNamespaces pattern.List
+	Kinds             []string
+	// ObjectKeys are top-level keys of objects besides the metadata read by the binding metric, WholeObject is set
+	// if keys are unknown. Only metadata of objects is watched if no binding of the resource reads other keys.
+	ObjectKeys  []string
+	WholeObject bool
+}
+
+// matches checks that the object should be stored to the binding metric. The namespace selector is checked
+// by the InformerController.
+func (b *Binding) matches(resource *unstructured.Unstructured) bool {
+	return b.watchesNamespace(resource.GetNamespace()) && b.watchesKind(resource.GetKind())
+}
+
+func (b *Binding) watchesNamespace(namespace string) bool {
+	if b.ExcludeNamespaces.MatchAny(namespace) {
+		return false
+	}
+	for _, ns := range b.Namespaces {
+		if ns == v1.NamespaceAll || ns == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Binding) watchesKind(kind string) bool {
+	if len(b.Kinds) == 0 {
+		return true
+	}
+	for _, k := range b.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// informerKey identifies the informer of the resource in the namespace.
+type informerKey struct {
+	namespace string
+	resource  Resource
+}
+
+type runningInformer struct {
+	informer cache.SharedIndexInformer
+	content  objectContent
+	health   *resourceHealth
+	events   *informerEvents
+	cancel   context.CancelFunc
+}
+
 // InformerController handles Kubernetes events for resourcess. The is the shim between metrics storage and Kubernetes cluster.
+// Informers are shared between all bindings, so each resource is watched only once per namespace.
 type InformerController struct {
-	client     dynamic.Interface
-	resources  []schema.GroupVersionResource
-	namespaces []string
+	client dynamic.Interface
+	// metadataClient watches resources only metadata of objects is needed of.
+	metadataClient metadata.Interface
+
+	mu sync.RWMutex
+	// all contains all bindings, their resources can be changed by SetResources.
+	all []*Binding
+	// bindings contains bindings fed by each resource.
+	bindings map[Resource][]*Binding
+	// static contains informers required by bindings without namespace selector.
+	static map[informerKey]struct{}
+	// informers contains currently running informers.
+	informers map[informerKey]*runningInformer
+	// failed contains health of required informers that failed to start.
+	failed map[informerKey]*resourceHealth
+	// selectedNamespaces contains namespaces matching the selector for each binding with the namespace selector.
+	selectedNamespaces map[*Binding]map[string]struct{}
+	// namespaceInformer watches namespaces if any binding has the namespace selector.
+	namespaceInformer *runningInformer
+	// synced is set after the initial sync of informers.
+	synced bool
 
 	metricCollector *collector.MetricsVault
+	// startTime is used to count only objects created after the exporter start, not ones from initial lists.
+	startTime time.Time
 }
 
 // NewResourcesInformer creates cached informer to track resources from a Kubernetes cluster.
-func NewResourcesInformer(config *rest.Config, namespaces []string, resources []schema.GroupVersionResource,
-	metricCollector *collector.MetricsVault) (*InformerController, error) {
+func NewResourcesInformer(config *rest.Config, bindings []Binding, metricCollector *collector.MetricsVault,
+	selfMetrics prometheus.Registerer) (*InformerController, error) {
 	client, err := dynamic.NewForConfig(config)
 	if err != nil {
 		return nil, err
 	}
+	metadataClient, err := metadata.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]*Binding, len(bindings))
+	selectedNamespaces := make(map[*Binding]map[string]struct{})
+	for i := range bindings {
+		all[i] = &bindings[i]
+		if bindings[i].NamespaceSelector != nil {
+			selectedNamespaces[&bindings[i]] = make(map[string]struct{})
+		}
+	}
+
+	c := &InformerController{
+		client:             client,
+		metadataClient:     metadataClient,
+		metricCollector:    metricCollector,
+		all:                all,
+		bindings:           resourceBindings(all),
+		static:             staticInformers(all),
+		informers:          make(map[informerKey]*runningInformer),
+		failed:             make(map[informerKey]*resourceHealth),
+		selectedNamespaces: selectedNamespaces,
+		startTime:          time.Now(),
+	}
+	if err := c.registerSelfMetrics(selfMetrics); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// staticInformers merges resources of bindings without namespace selector by namespace. Resources watched
+// in all namespaces are not watched in specific namespaces.
+func staticInformers(bindings []*Binding) map[informerKey]struct{} {
+	resourceNamespaces := make(map[Resource]map[string]struct{})
+	for _, binding := range bindings {
+		if binding.NamespaceSelector != nil {
+			continue
+		}
+		for _, resource := range binding.Resources {
+			if _, ok := resourceNamespaces[resource]; !ok {
+				resourceNamespaces[resource] = make(map[string]struct{})
+			}
+			for _, namespace := range binding.Namespaces {
+				resourceNamespaces[resource][namespace] = struct{}{}
+			}
+		}
+	}
+
+	result := make(map[informerKey]struct{})
+	for resource, namespaces := range resourceNamespaces {
+		if _, ok := namespaces[v1.NamespaceAll]; ok {
+			namespaces = map[string]struct{}{v1.NamespaceAll: {}}
+		}
+		for namespace := range namespaces {
+			result[informerKey{namespace: namespace, resource: resource}] = struct{}{}
+		}
+	}
+	return result
+}
+
+// resourceBindings indexes bindings by resources they are fed by.
+func resourceBindings(bindings []*Binding) map[Resource][]*Binding {
+	result := make(map[Resource][]*Binding)
+	for _, binding := range bindings {
+		for _, resource := range binding.Resources {
+			result[resource] = append(result[resource], binding)
+		}
+	}
+	return result
+}
 
-	return &InformerController{
-		client:          client,
-		metricCollector: metricCollector,
-		resources:       resources,
-		namespaces:      namespaces,
-	}, nil
+// matchingBindings returns bindings of the resource the object should be stored to including the namespace selector.
+// Must be called with the lock held.
+func (i *InformerController) matchingBindings(resource Resource, obj *unstructured.Unstructured) []*Binding {
+	var result []*Binding
+	for _, binding := range i.bindings[resource] {
+		if i.bindingMatches(binding, obj) {
+			result = append(result, binding)
+		}
+	}
+	return result
 }
 
-func (i *InformerController) storeMetric(obj interface{}) {
-	resource := obj.(*unstructured.Unstructured)
-	i.metricCollector.Store(ExporterMetricName, ResourceToSample(resource))
+// bindingMatches checks that the object should be stored to the binding metric including the namespace selector.
+// Must be called with the lock held.
+func (i *InformerController) bindingMatches(binding *Binding, obj *unstructured.Unstructured) bool {
+	if !binding.matches(obj) {
+		return false
+	}
+	if binding.NamespaceSelector == nil {
+		return true
+	}
+	_, ok := i.selectedNamespaces[binding][obj.GetNamespace()]
+	return ok
 }
 
-func (i *InformerController) addHandler() func(obj interface{}) {
-	return i.storeMetric
+func (i *InformerController) storeMetric(resource Resource, obj interface{}) []*Binding {
+	object := obj.(*unstructured.Unstructured)
+	sample := ResourceToSample(object)
+	// Objects are stored with the lock held, so the reconcile doesn't remove objects that are being stored.
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	bindings := i.matchingBindings(resource, object)
+	for _, binding := range bindings {
+		i.metricCollector.Store(binding.MetricName, sample)
+	}
+	return bindings
 }
 
-func (i *InformerController) updateHandler() func(old, new interface{}) {
+func (i *InformerController) addHandler(resource Resource, events *informerEvents) func(obj interface{}) {
+	return func(obj interface{}) {
+		events.handled(handlerAdd)
+		bindings := i.storeMetric(resource, obj)
+		// Objects from initial lists were created before the exporter start.
+		if len(bindings) > 0 && !obj.(*unstructured.Unstructured).GetCreationTimestamp().Time.Before(i.startTime) {
+			objectsCreated.WithLabelValues(resource.Group, resource.Version, resource.Resource).Inc()
+		}
+	}
+}
+
+func (i *InformerController) updateHandler(resource Resource, events *informerEvents) func(old, new interface{}) {
 	return func(old, new interface{}) {
-		i.storeMetric(new)
+		events.handled(handlerUpdate)
+		i.storeMetric(resource, new)
 	}
 }
 
-func (i *InformerController) deleteHandler() func(obj interface{}) {
+func (i *InformerController) deleteHandler(resource Resource, events *informerEvents) func(obj interface{}) {
 	return func(obj interface{}) {
-		resource := obj.(*unstructured.Unstructured)
-		i.metricCollector.Clear(ExporterMetricName, ResourceToSample(resource))
+		events.handled(handlerDelete)
+		object, ok := deletedObject(obj)
+		if !ok {
+			log.Printf("unexpected deleted object of resource %s: %T", resource.String(), obj)
+			return
+		}
+		sample := ResourceToSample(object)
+		i.mu.RLock()
+		bindings := i.matchingBindings(resource, object)
+		for _, binding := range bindings {
+			i.metricCollector.Clear(binding.MetricName, sample)
+		}
+		i.mu.RUnlock()
+		if len(bindings) > 0 {
+			objectsDeleted.WithLabelValues(resource.Group, resource.Version, resource.Resource).Inc()
+		}
+	}
+}
+
+// deletedObject returns the deleted object. The informer delivers the tombstone with the last known state of the object
+// if the delete event was missed and the object is found deleted on relist.
+func deletedObject(obj interface{}) (*unstructured.Unstructured, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	object, ok := obj.(*unstructured.Unstructured)
+	return object, ok
+}
+
+// SetResources replaces resources of the binding with the metric name and starts or stops informers accordingly.
+// Objects of added resources watched by already running informers are stored to the binding metric.
+func (c *InformerController) SetResources(ctx context.Context, metricName string, resources []Resource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	added := make(map[*Binding][]Resource)
+	for _, binding := range c.all {
+		if binding.MetricName != metricName {
+			continue
+		}
+		for _, resource := range resources {
+			if !containsResource(binding.Resources, resource) {
+				added[binding] = append(added[binding], resource)
+			}
+		}
+		binding.Resources = resources
+	}
+
+	running := make(map[informerKey]*runningInformer, len(c.informers))
+	for key, informer := range c.informers {
+		running[key] = informer
+	}
+
+	fedBy := c.bindings
+	c.bindings = resourceBindings(c.all)
+	c.static = staticInformers(c.all)
+	c.syncInformers(ctx, fedBy)
+
+	// Newly started informers store objects from their add events.
+	for binding, resources := range added {
+		for key, informer := range running {
+			// Informers restarted with another content store objects from their add events too.
+			if !containsResource(resources, key.resource) || c.informers[key] != informer {
+				continue
+			}
+			for _, obj := range informer.informer.GetStore().List() {
+				if object := obj.(*unstructured.Unstructured); c.bindingMatches(binding, object) {
+					c.metricCollector.Store(binding.MetricName, ResourceToSample(object))
+				}
+			}
+		}
 	}
 }
 
+func containsResource(resources []Resource, resource Resource) bool {
+	for _, r := range resources {
+		if r == resource {
+			return true
+		}
+	}
+	return false
+}
+
 // Run starts the informers for different resources with various handlers and waits for the first cache synchronization.
-func (c *InformerController) Run(ctx context.Context, errorCh chan<- error) {
-	for _, namespace := range c.namespaces {
-		go c.runInformerForNamespace(ctx, namespace, errorCh)
+// Informers for bindings with the namespace selector are started and stopped when namespaces are changed.
+func (c *InformerController) Run(ctx context.Context) {
+	c.mu.Lock()
+	started := c.syncInformers(ctx, c.bindings)
+	c.mu.Unlock()
+
+	if len(c.selectedNamespaces) > 0 {
+		go c.runNamespaceInformer(ctx)
 	}
 	log.Println("started")
+
+	// Informers of failing resources retry requests until the context is done. Informers are waited separately,
+	// so informers of unavailable resources, e.g. forbidden by RBAC, don't block the sync of others.
+	var wg sync.WaitGroup
+	for _, running := range started {
+		wg.Add(1)
+		go func(running *runningInformer) {
+			defer wg.Done()
+			if running.waitForSync(ctx) && !running.informer.HasSynced() {
+				health := running.health.get()
+				log.Printf("resource %s in namespace '%s' is not synced: %s: %v", health.Resource.String(), health.Namespace,
+					health.Reason, health.Error)
+			}
+		}(running)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		log.Println("informer caches are not synced, exiting")
+		return
+	}
+	// All existing objects are stored, so restored histories of objects deleted while the exporter was down are removed.
+	// Histories of objects of unavailable resources are removed too, since their objects can't be confirmed.
+	c.metricCollector.PruneRestored()
+
+	c.mu.Lock()
+	c.synced = true
+	c.mu.Unlock()
+	firstSyncDuration.Set(time.Since(c.startTime).Seconds())
+	log.Printf("informer caches are synced in %v", time.Since(c.startTime))
 }
 
-func (c *InformerController) runInformerForNamespace(ctx context.Context, namespace string, errorCh chan<- error) {
-	factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(c.client, time.Minute, namespace, nil)
-	cacheSyncs := make([]cache.InformerSynced, len(c.resources))
-	for i, resource := range c.resources {
-		informer, err := c.newInformer(factory, resource, errorCh)
+// syncInformers starts required informers and stops informers that are not required anymore. Informers caching
+// another content than bindings need are restarted. Metrics of objects from stopped informers are cleared
+// for bindings fed by their resources. Informers that fail to start are reported to their resource health,
+// and their start is retried on the next sync. It returns started informers. Must be called with the lock held.
+func (c *InformerController) syncInformers(ctx context.Context, fedBy map[Resource][]*Binding) []*runningInformer {
+	required := c.requiredInformers()
+
+	for key, health := range c.failed {
+		health.stop()
+		delete(c.failed, key)
+	}
+
+	for key, running := range c.informers {
+		if _, ok := required[key]; ok && running.content == c.content(key.resource) {
+			continue
+		}
+		running.cancel()
+		running.health.stop()
+		delete(c.informers, key)
+		for _, obj := range running.informer.GetStore().List() {
+			sample := ResourceToSample(obj.(*unstructured.Unstructured))
+			for _, binding := range fedBy[key.resource] {
+				c.metricCollector.Clear(binding.MetricName, sample)
+			}
+		}
+		log.Printf("stopped watching for resource %s in namespace '%s'", key.resource.String(), key.namespace)
+	}
+
+	var started []*runningInformer
+	for key := range required {
+		if _, ok := c.informers[key]; ok {
+			continue
+		}
+		health := newResourceHealth(key.namespace, key.resource)
+		events := &informerEvents{resource: key.resource}
+		content := c.content(key.resource)
+		informer, err := c.newInformer(key.namespace, key.resource, content, health, events)
 		if err != nil {
-			errorCh <- err
+			log.Printf("failed to start watching for resource %s in namespace '%s': %v", key.resource.String(), key.namespace, err)
+			health.failedWith(WatchErrorSetup, err)
+			c.failed[key] = health
+			continue
 		}
-		cacheSyncs[i] = informer.HasSynced
+		informerCtx, cancel := context.WithCancel(ctx)
+		running := &runningInformer{informer: informer, content: content, health: health, events: events, cancel: cancel}
+		c.informers[key] = running
+		go informer.Run(informerCtx.Done())
+		started = append(started, running)
+		log.Printf("started watching for resource %s in namespace '%s', caching %s", key.resource.String(), key.namespace, content)
 	}
+	return started
+}
 
-	factory.Start(ctx.Done())
-	log.Printf("started factory for namespace '%s'", namespace)
-	if ok := cache.WaitForCacheSync(ctx.Done(), cacheSyncs...); !ok {
-		log.Fatal(fmt.Errorf("informer cache is not synced"))
-		errorCh <- fmt.Errorf("informer cache is not synced")
+// requiredInformers returns static informers and informers for namespaces selected by bindings namespace selectors.
+// Selected namespaces are not watched separately if the resource is already watched in all namespaces.
+func (c *InformerController) requiredInformers() map[informerKey]struct{} {
+	required := make(map[informerKey]struct{}, len(c.static))
+	for key := range c.static {
+		required[key] = struct{}{}
 	}
+	for binding, namespaces := range c.selectedNamespaces {
+		for _, resource := range binding.Resources {
+			if _, ok := c.static[informerKey{namespace: v1.NamespaceAll, resource: resource}]; ok {
+				continue
+			}
+			for namespace := range namespaces {
+				required[informerKey{namespace: namespace, resource: resource}] = struct{}{}
+			}
+		}
+	}
+	return required
 }
 
-func (i *InformerController) newInformer(factory dynamicinformer.DynamicSharedInformerFactory, resource schema.GroupVersionResource, errorCh chan<- error) (cache.SharedIndexInformer, error) {
-	informer := factory.ForResource(resource).Informer()
+// newInformer creates the informer of the resource in the namespace caching the content of objects. The informer
+// retries failed list and watch requests with backoff, errors are reported to the resource health, so other
+// resources are watched as usual.
+func (i *InformerController) newInformer(namespace string, resource Resource, content objectContent, health *resourceHealth,
+	events *informerEvents) (cache.SharedIndexInformer, error) {
+	lw, objType := i.listWatch(namespace, resource, content)
+	informer := cache.NewSharedIndexInformer(newListWatch(lw, resource, health), objType,
+		time.Minute, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if err := informer.SetTransform(content.transform(resource)); err != nil {
+		return nil, fmt.Errorf("failed to set transform: %w", err)
+	}
 	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
-		AddFunc:    i.addHandler(),
-		UpdateFunc: i.updateHandler(),
-		DeleteFunc: i.deleteHandler(),
+		AddFunc:    i.addHandler(resource, events),
+		UpdateFunc: i.updateHandler(resource, events),
+		DeleteFunc: i.deleteHandler(resource, events),
 	})
-	if err := informer.SetWatchErrorHandler(func(_ *cache.Reflector, err error) {
-		errorCh <- fmt.Errorf("error for resource '%v': %v", resource, err)
+	if err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		health.failed(err)
 	}); err != nil {
 		return nil, fmt.Errorf("failed to set watch error handler: %w", err)
 	}
@@ -0,0 +1,32 @@
+package kube
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestDeletedObject(t *testing.T) {
+	object := &unstructured.Unstructured{}
+	object.SetName("a")
+
+	tests := []struct {
+		name     string
+		obj      interface{}
+		expected *unstructured.Unstructured
+	}{
+		{name: "object", obj: object, expected: object},
+		{name: "tombstone", obj: cache.DeletedFinalStateUnknown{Key: "default/a", Obj: object}, expected: object},
+		{name: "tombstone of unexpected object", obj: cache.DeletedFinalStateUnknown{Key: "default/a", Obj: "a"}},
+		{name: "unexpected object", obj: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, ok := deletedObject(tt.obj)
+			if ok != (tt.expected != nil) || result != tt.expected {
+				t.Errorf("expected %v, got %v, %v", tt.expected, result, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package kube
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PendingResources reports configured resources that are not served by the kubernetes api yet.
+var PendingResources = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: collector.ApplicationPrefix + "pending_resources",
+	Help: "Configured resources that are not served by the kubernetes api, 1 while the resource is pending",
+}, []string{"metric", "resource"})
+
+// objectsCreated counts objects created after the exporter start, objects from initial lists are not counted.
+var objectsCreated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: collector.ApplicationPrefix + "objects_created_total",
+	Help: "Number of created objects of the resource exported by any metric",
+}, []string{"group", "version", "resource"})
+
+var objectsDeleted = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: collector.ApplicationPrefix + "objects_deleted_total",
+	Help: "Number of deleted objects of the resource exported by any metric",
+}, []string{"group", "version", "resource"})
+
+// resourceHealthy reports the health of informers, series of stopped informers are removed.
+var resourceHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: collector.ApplicationPrefix + "resource_healthy",
+	Help: "Whether the last list or watch request of the resource in the namespace succeeded, 0 while the informer retries failed requests",
+}, []string{"namespace", "group", "version", "resource", "label_selector", "field_selector"})
+
+var watchErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: collector.ApplicationPrefix + "watch_errors_total",
+	Help: "Number of failed list and watch requests of the resource in the namespace by reason: forbidden, not_found or transient",
+}, []string{"namespace", "group", "version", "resource", "reason"})
+
+var firstSyncDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: collector.ApplicationPrefix + "first_sync_duration_seconds",
+	Help: "Time from the exporter start until caches of all initially started informers are synced",
+})
+
+// events counts events of objects by the handler: add, update or delete.
+var events = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: collector.ApplicationPrefix + "events_total",
+	Help: "Number of handled events of objects of the resource by the handler type",
+}, []string{"handler", "group", "version", "resource"})
+
+// RegisterMetrics registers self metrics shared by all controllers.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{PendingResources, objectsCreated, objectsDeleted, resourceHealthy, watchErrors,
+		firstSyncDuration, events} {
+		if err := registerer.Register(c); err != nil {
+			return fmt.Errorf("self metrics registration: %v", err)
+		}
+	}
+	return nil
+}
+
+const (
+	handlerAdd    = "add"
+	handlerUpdate = "update"
+	handlerDelete = "delete"
+)
+
+// informerEvents records events handled by the informer.
+type informerEvents struct {
+	resource Resource
+	// last is the unix time of the last event in nanoseconds.
+	last atomic.Int64
+}
+
+func (e *informerEvents) handled(handler string) {
+	events.WithLabelValues(handler, e.resource.Group, e.resource.Version, e.resource.Resource).Inc()
+	e.last.Store(time.Now().UnixNano())
+}
+
+var (
+	watchedResourcesDesc = prometheus.NewDesc(collector.ApplicationPrefix+"watched_resources",
+		"Number of watched resources", nil, nil)
+	watchedNamespacesDesc = prometheus.NewDesc(collector.ApplicationPrefix+"watched_namespaces",
+		"Number of namespaces resources are watched in, all namespaces are counted as one", nil, nil)
+	cachedObjectsDesc = prometheus.NewDesc(collector.ApplicationPrefix+"cached_objects",
+		"Number of cached objects of the resource in all informers", []string{"group", "version", "resource"}, nil)
+	lastEventDesc = prometheus.NewDesc(collector.ApplicationPrefix+"last_event_timestamp_seconds",
+		"Time of the last event handled by the informer of the resource in the namespace",
+		[]string{"namespace", "group", "version", "resource", "label_selector", "field_selector"}, nil)
+)
+
+// controllerCollector collects the state of informers of the controller on scrape.
+type controllerCollector struct {
+	controller *InformerController
+}
+
+func (c controllerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- watchedResourcesDesc
+	ch <- watchedNamespacesDesc
+	ch <- cachedObjectsDesc
+	ch <- lastEventDesc
+}
+
+func (c controllerCollector) Collect(ch chan<- prometheus.Metric) {
+	c.controller.mu.RLock()
+	defer c.controller.mu.RUnlock()
+
+	resources := make(map[Resource]struct{})
+	namespaces := make(map[string]struct{})
+	objects := make(map[[3]string]int)
+	for key, running := range c.controller.informers {
+		resources[key.resource] = struct{}{}
+		namespaces[key.namespace] = struct{}{}
+		objects[[3]string{key.resource.Group, key.resource.Version, key.resource.Resource}] += len(running.informer.GetStore().ListKeys())
+
+		if last := running.events.last.Load(); last != 0 {
+			ch <- prometheus.MustNewConstMetric(lastEventDesc, prometheus.GaugeValue, float64(last)/float64(time.Second),
+				key.namespace, key.resource.Group, key.resource.Version, key.resource.Resource,
+				key.resource.LabelSelector, key.resource.FieldSelector)
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(watchedResourcesDesc, prometheus.GaugeValue, float64(len(resources)))
+	ch <- prometheus.MustNewConstMetric(watchedNamespacesDesc, prometheus.GaugeValue, float64(len(namespaces)))
+	for gvr, count := range objects {
+		ch <- prometheus.MustNewConstMetric(cachedObjectsDesc, prometheus.GaugeValue, float64(count), gvr[0], gvr[1], gvr[2])
+	}
+}
+
+// registerSelfMetrics registers self metrics of the controller.
+func (c *InformerController) registerSelfMetrics(registerer prometheus.Registerer) error {
+	if err := registerer.Register(controllerCollector{controller: c}); err != nil {
+		return fmt.Errorf("self metrics registration: %v", err)
+	}
+	return nil
+}
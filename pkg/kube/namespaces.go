@@ -0,0 +1,154 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/tools/cache"
+)
+
+var namespacesResource = schema.GroupVersionResource{Version: "v1", Resource: "namespaces"}
+
+// runNamespaceInformer watches namespaces to select them by bindings namespace selectors, when namespaces
+// are created, relabelled or deleted.
+func (c *InformerController) runNamespaceInformer(ctx context.Context) {
+	// Only labels of namespaces are needed, so their metadata is watched.
+	resource := Resource{GroupVersionResource: namespacesResource, Kind: "Namespace"}
+	health := newResourceHealth(v1.NamespaceAll, resource)
+	content := objectContent{}
+	lw, objType := c.listWatch(v1.NamespaceAll, resource, content)
+	informer := cache.NewSharedIndexInformer(newListWatch(lw, resource, health), objType, 0, cache.Indexers{})
+	if err := informer.SetTransform(content.transform(resource)); err != nil {
+		namespaceInformerFailed(health, fmt.Errorf("failed to set transform: %w", err))
+		return
+	}
+
+	reconcile := func() {
+		if informer.HasSynced() {
+			c.selectNamespaces(ctx, informer.GetStore().List())
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			reconcile()
+		},
+		UpdateFunc: func(old, new interface{}) {
+			oldLabels := old.(*unstructured.Unstructured).GetLabels()
+			newLabels := new.(*unstructured.Unstructured).GetLabels()
+			if !reflect.DeepEqual(oldLabels, newLabels) {
+				reconcile()
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			reconcile()
+		},
+	})
+	if err := informer.SetWatchErrorHandler(func(r *cache.Reflector, err error) {
+		cache.DefaultWatchErrorHandler(r, err)
+		health.failed(err)
+	}); err != nil {
+		namespaceInformerFailed(health, fmt.Errorf("failed to set watch error handler: %w", err))
+		return
+	}
+
+	c.mu.Lock()
+	c.namespaceInformer = &runningInformer{informer: informer, health: health}
+	c.mu.Unlock()
+
+	go informer.Run(ctx.Done())
+	if ok := cache.WaitForCacheSync(ctx.Done(), informer.HasSynced); !ok {
+		return
+	}
+	reconcile()
+}
+
+// namespaceInformerFailed reports the namespace informer that can't be started. Namespaces are not selected then,
+// so only resources of bindings without the namespace selector are watched.
+func namespaceInformerFailed(health *resourceHealth, err error) {
+	log.Printf("failed to start watching for namespaces, namespace selectors are not applied: %v", err)
+	health.failedWith(WatchErrorSetup, err)
+}
+
+// selectNamespaces updates namespaces selected by each binding and starts or stops informers for them.
+// Metrics of objects from namespaces that are not selected anymore are cleared, and objects from newly selected
+// namespaces watched by already running informers are stored.
+func (c *InformerController) selectNamespaces(ctx context.Context, namespaces []interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	added := make(map[*Binding][]string)
+	for binding, selected := range c.selectedNamespaces {
+		newSelected := make(map[string]struct{})
+		for _, obj := range namespaces {
+			namespace := obj.(*unstructured.Unstructured)
+			if binding.selectsNamespace(namespace) {
+				newSelected[namespace.GetName()] = struct{}{}
+			}
+		}
+
+		for namespace := range selected {
+			if _, ok := newSelected[namespace]; !ok {
+				c.clearNamespace(binding, namespace)
+				log.Printf("namespace '%s' is not selected for metric %s anymore", namespace, binding.MetricName)
+			}
+		}
+		for namespace := range newSelected {
+			if _, ok := selected[namespace]; !ok {
+				added[binding] = append(added[binding], namespace)
+				log.Printf("namespace '%s' is selected for metric %s", namespace, binding.MetricName)
+			}
+		}
+		c.selectedNamespaces[binding] = newSelected
+	}
+
+	running := make(map[informerKey]*runningInformer, len(c.informers))
+	for key, informer := range c.informers {
+		running[key] = informer
+	}
+	c.syncInformers(ctx, c.bindings)
+
+	// Newly started informers store objects from their add events.
+	for binding, namespaces := range added {
+		for _, namespace := range namespaces {
+			for _, resource := range binding.Resources {
+				for _, obj := range namespaceObjects(running, resource, namespace) {
+					if resource := obj.(*unstructured.Unstructured); binding.matches(resource) {
+						c.metricCollector.Store(binding.MetricName, ResourceToSample(resource))
+					}
+				}
+			}
+		}
+	}
+}
+
+// selectsNamespace checks that the namespace is watched by the binding and matches its namespace selector.
+func (b *Binding) selectsNamespace(namespace *unstructured.Unstructured) bool {
+	return b.watchesNamespace(namespace.GetName()) && b.NamespaceSelector.Matches(labels.Set(namespace.GetLabels()))
+}
+
+// clearNamespace clears the binding metrics for objects from the namespace. Must be called with the lock held.
+func (c *InformerController) clearNamespace(binding *Binding, namespace string) {
+	for _, resource := range binding.Resources {
+		for _, obj := range namespaceObjects(c.informers, resource, namespace) {
+			c.metricCollector.Clear(binding.MetricName, ResourceToSample(obj.(*unstructured.Unstructured)))
+		}
+	}
+}
+
+// namespaceObjects returns cached objects of the resource from the namespace watched by one of informers.
+func namespaceObjects(informers map[informerKey]*runningInformer, resource Resource, namespace string) []interface{} {
+	if running, ok := informers[informerKey{namespace: namespace, resource: resource}]; ok {
+		return running.informer.GetStore().List()
+	}
+	if running, ok := informers[informerKey{namespace: v1.NamespaceAll, resource: resource}]; ok {
+		objs, _ := running.informer.GetIndexer().ByIndex(cache.NamespaceIndex, namespace)
+		return objs
+	}
+	return nil
+}
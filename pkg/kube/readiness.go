@@ -0,0 +1,56 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// syncPollPeriod is the period of informer sync checks, the same as of cache.WaitForCacheSync.
+const syncPollPeriod = 100 * time.Millisecond
+
+// Unready returns descriptions of informers whose caches are not synced yet, including the reason of failing requests.
+// The exporter is not ready until the initial sync of all informers, and while informers started later, e.g. for
+// newly selected namespaces or installed resources, are syncing. Informers of unavailable resources are skipped,
+// so one forbidden resource doesn't stop scrapes of all other metrics, they are reported by resource health.
+func (c *InformerController) Unready() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var result []string
+	if c.namespaceInformer != nil && !c.namespaceInformer.syncedOrUnavailable() {
+		result = append(result, unsyncedInformer(c.namespaceInformer.health.get()))
+	}
+	for _, running := range c.informers {
+		if !running.syncedOrUnavailable() {
+			result = append(result, unsyncedInformer(running.health.get()))
+		}
+	}
+	if len(result) == 0 && !c.synced {
+		result = append(result, "informers are not started")
+	}
+	return result
+}
+
+func (r *runningInformer) syncedOrUnavailable() bool {
+	return r.informer.HasSynced() || r.health.get().Unavailable()
+}
+
+// waitForSync waits until the informer is synced or its resource is unavailable. It returns false if the context
+// is done first.
+func (r *runningInformer) waitForSync(ctx context.Context) bool {
+	err := wait.PollImmediateUntil(syncPollPeriod, func() (bool, error) {
+		return r.syncedOrUnavailable(), nil
+	}, ctx.Done())
+	return err == nil
+}
+
+func unsyncedInformer(health ResourceHealth) string {
+	result := fmt.Sprintf("resource %s in namespace '%s' is not synced", health.Resource.String(), health.Namespace)
+	if !health.Healthy() {
+		result += fmt.Sprintf(": %s: %v", health.Reason, health.Error)
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package kube
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// RunReconcile reconciles stored objects with informer caches on every interval until the context is done.
+func (c *InformerController) RunReconcile(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		c.Reconcile()
+	}
+}
+
+// Reconcile removes objects stored to metrics that are not found in informer caches anymore, e.g. if their delete
+// events were missed. Metrics fed by not synced informers are skipped, their caches may be incomplete.
+func (c *InformerController) Reconcile() {
+	// Handlers store objects with the read lock held after caches are updated, so all stored objects are listed.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, binding := range c.all {
+		live, synced := c.liveSamples(binding)
+		if !synced {
+			continue
+		}
+		if removed := c.metricCollector.Reconcile(binding.MetricName, live); removed > 0 {
+			log.Printf("removed %d orphaned objects from metric %s", removed, binding.MetricName)
+		}
+	}
+}
+
+// liveSamples returns samples of cached objects the binding metric is fed by and false if any of informers
+// is not synced. Must be called with the lock held.
+func (c *InformerController) liveSamples(binding *Binding) ([]collector.Sample, bool) {
+	var live []collector.Sample
+	for key, running := range c.informers {
+		if !containsResource(binding.Resources, key.resource) {
+			continue
+		}
+		if !running.informer.HasSynced() {
+			return nil, false
+		}
+		for _, obj := range running.informer.GetStore().List() {
+			if object := obj.(*unstructured.Unstructured); c.bindingMatches(binding, object) {
+				live = append(live, ResourceToSample(object))
+			}
+		}
+	}
+	return live, true
+}
@@ -38,24 +38,24 @@ func ResourceToSample(resource *unstructured.Unstructured) collector.Sample {
 		ResourceLabels:      labels,
 		ResourceAnnotations: annotations,
 		ResourceMeta:        resourceMeta,
+		Object:              resource.Object,
+		UID:                 string(resource.GetUID()),
+		Generation:          resource.GetGeneration(),
+		ResourceVersion:     resource.GetResourceVersion(),
 	}
 }
 
 // ResourceMapping creates the mapping for the prometheus metrics vault. The order of the labels here should match the one
 // from the sample converter function.
 func ResourceMapping(kubeLabelNames, kubeAnnotationsNames []string, maxRevisions int, onlyLabelsAndAnnotations bool, referenceLabels, referenceAnnotations []string) collector.Mapping {
-	resourceMeta := make([]string, 0)
-	if !onlyLabelsAndAnnotations {
-		resourceMeta = []string{"api_version", "kind", "namespace", "name"}
-	}
 	return collector.Mapping{
 		Name: ExporterMetricName,
-		Help: "Expose Kubernetes annotations and lables from kubernetes objects",
+		Help: ExporterMetricHelp,
 
 		ReferenceLabels:      referenceLabels,
 		ReferenceAnnotations: referenceAnnotations,
 
-		KubeResourceMeta: resourceMeta,
+		KubeResourceMeta: ResourceMetaLabels(onlyLabelsAndAnnotations),
 		KubeLabels:       kubeLabelNames,
 		KubeAnnotations:  kubeAnnotationsNames,
 
@@ -63,3 +63,22 @@ func ResourceMapping(kubeLabelNames, kubeAnnotationsNames []string, maxRevisions
 		OnlyLabelsAndAnnotations: onlyLabelsAndAnnotations,
 	}
 }
+
+// ResourceMetaLabels returns the label names for the resource meta from the sample converter function.
+func ResourceMetaLabels(onlyLabelsAndAnnotations bool) []string {
+	if onlyLabelsAndAnnotations {
+		return make([]string, 0)
+	}
+	return []string{"api_version", "kind", "namespace", "name"}
+}
+
+// CompleteMapping fills the mapping fields omitted in the config file with the values matching the sample converter function.
+func CompleteMapping(mapping collector.Mapping) collector.Mapping {
+	if mapping.Help == "" {
+		mapping.Help = ExporterMetricHelp
+	}
+	if len(mapping.KubeResourceMeta) == 0 {
+		mapping.KubeResourceMeta = ResourceMetaLabels(mapping.OnlyLabelsAndAnnotations)
+	}
+	return mapping
+}
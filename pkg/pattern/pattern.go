@@ -0,0 +1,94 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pattern
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// RegexpPrefix marks the pattern as a regular expression, e.g. "~^team\.example\.com/.+$".
+	RegexpPrefix = "~"
+	globChars    = "*?"
+)
+
+// Pattern matches Kubernetes names and keys exactly, by glob with "*" and "?" wildcards
+// or by regular expression prefixed with "~".
+type Pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+// IsPattern checks that the string is a glob or a regular expression and not an exact name.
+func IsPattern(s string) bool {
+	return strings.HasPrefix(s, RegexpPrefix) || strings.ContainsAny(s, globChars)
+}
+
+// Compile parses the pattern string.
+func Compile(s string) (*Pattern, error) {
+	switch {
+	case strings.HasPrefix(s, RegexpPrefix):
+		re, err := regexp.Compile(strings.TrimPrefix(s, RegexpPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", s, err)
+		}
+		return &Pattern{raw: s, re: re}, nil
+	case strings.ContainsAny(s, globChars):
+		expr := regexp.QuoteMeta(s)
+		expr = strings.ReplaceAll(expr, `\*`, ".*")
+		expr = strings.ReplaceAll(expr, `\?`, ".")
+		return &Pattern{raw: s, re: regexp.MustCompile("^" + expr + "$")}, nil
+	}
+	return &Pattern{raw: s}, nil
+}
+
+// Match checks that the name matches the pattern.
+func (p *Pattern) Match(name string) bool {
+	if p.re == nil {
+		return p.raw == name
+	}
+	return p.re.MatchString(name)
+}
+
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// List is the list of patterns matching names by any of them.
+type List []*Pattern
+
+// CompileList parses all pattern strings.
+func CompileList(patterns []string) (List, error) {
+	result := make(List, len(patterns))
+	for i, s := range patterns {
+		p, err := Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = p
+	}
+	return result, nil
+}
+
+// MatchAny checks that the name matches any pattern from the list.
+func (l List) MatchAny(name string) bool {
+	for _, p := range l {
+		if p.Match(name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,98 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pattern
+
+import (
+	"testing"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern   string
+		isPattern bool
+		matches   []string
+		others    []string
+	}{
+		{pattern: "app", matches: []string{"app"}, others: []string{"apps", "ap", ""}},
+		{pattern: "app.kubernetes.io/*", isPattern: true,
+			matches: []string{"app.kubernetes.io/name", "app.kubernetes.io/"}, others: []string{"appxkubernetes.io/name", "my.app.kubernetes.io/name"}},
+		{pattern: "team-?", isPattern: true, matches: []string{"team-a", "team-1"}, others: []string{"team-", "team-ab"}},
+		{pattern: "*.werf.io/*", isPattern: true, matches: []string{"ci.werf.io/commit"}, others: []string{"werf.io/commit"}},
+		// Regular expressions are not anchored.
+		{pattern: "~werf", isPattern: true, matches: []string{"werf.io/version", "ci.werf.io/commit"}, others: []string{"helm.sh/chart"}},
+		{pattern: `~^gatekeeper-.+$`, isPattern: true, matches: []string{"gatekeeper-system"}, others: []string{"gatekeeper-", "my-gatekeeper-system"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			if IsPattern(tt.pattern) != tt.isPattern {
+				t.Errorf("expected IsPattern %v", tt.isPattern)
+			}
+			p, err := Compile(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range tt.matches {
+				if !p.Match(name) {
+					t.Errorf("expected %q to match", name)
+				}
+			}
+			for _, name := range tt.others {
+				if p.Match(name) {
+					t.Errorf("expected %q not to match", name)
+				}
+			}
+		})
+	}
+}
+
+func TestCompileInvalidRegexp(t *testing.T) {
+	for _, s := range []string{"~(", "~[a-", "~a{2,1}"} {
+		if _, err := Compile(s); err == nil {
+			t.Errorf("expected error for %q", s)
+		}
+	}
+	// Globs are quoted, so regular expression syntax is matched literally.
+	p, err := Compile("a(*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Match("a(b") || p.Match("ab") {
+		t.Errorf("expected glob to match regular expression characters literally")
+	}
+}
+
+func TestListMatchAny(t *testing.T) {
+	if _, err := CompileList([]string{"kube-system", "~("}); err == nil {
+		t.Error("expected error for the invalid pattern in the list")
+	}
+	list, err := CompileList([]string{"kube-system", "cattle-*", "~^gatekeeper-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{
+		"kube-system":       true,
+		"cattle-system":     true,
+		"gatekeeper-system": true,
+		"default":           false,
+		"kube-public":       false,
+	} {
+		if list.MatchAny(name) != expected {
+			t.Errorf("expected MatchAny(%q) to be %v", name, expected)
+		}
+	}
+	if List(nil).MatchAny("default") {
+		t.Error("expected the empty list to match nothing")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	configMapKey = "snapshot.json"
+	// configMapMaxDataSize is the max size of the snapshot in the ConfigMap. The whole object is limited to 1MiB,
+	// so the rest is left for the metadata.
+	configMapMaxDataSize = 1<<20 - 16<<10
+)
+
+var configMapsResource = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+
+// ConfigMapBackend stores the snapshot in the ConfigMap, so it survives rescheduling of the exporter pod
+// without persistent volumes. ConfigMaps are limited to 1MiB, larger snapshots are not saved.
+type ConfigMapBackend struct {
+	client    dynamic.ResourceInterface
+	namespace string
+	name      string
+}
+
+func NewConfigMapBackend(config *rest.Config, namespace, name string) (*ConfigMapBackend, error) {
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &ConfigMapBackend{client: client.Resource(configMapsResource).Namespace(namespace), namespace: namespace, name: name}, nil
+}
+
+func (b *ConfigMapBackend) Load(ctx context.Context) ([]byte, error) {
+	configMap, err := b.client.Get(ctx, b.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, found, err := unstructured.NestedString(configMap.Object, "data", configMapKey)
+	if err != nil || !found {
+		return nil, err
+	}
+	return []byte(data), nil
+}
+
+func (b *ConfigMapBackend) Save(ctx context.Context, data []byte) error {
+	if len(data) > configMapMaxDataSize {
+		return fmt.Errorf("%w for configmap %s/%s: %d bytes, max %d bytes, use the file backend or limit revisions",
+			ErrSnapshotTooLarge, b.namespace, b.name, len(data), configMapMaxDataSize)
+	}
+	configMap, err := b.client.Get(ctx, b.name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		configMap = &unstructured.Unstructured{}
+		configMap.SetAPIVersion("v1")
+		configMap.SetKind("ConfigMap")
+		configMap.SetNamespace(b.namespace)
+		configMap.SetName(b.name)
+		if err := unstructured.SetNestedField(configMap.Object, string(data), "data", configMapKey); err != nil {
+			return err
+		}
+		_, err = b.client.Create(ctx, configMap, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := unstructured.SetNestedField(configMap.Object, string(data), "data", configMapKey); err != nil {
+		return err
+	}
+	_, err = b.client.Update(ctx, configMap, metav1.UpdateOptions{})
+	return err
+}
@@ -0,0 +1,52 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// FileBackend stores the snapshot in the local file, e.g. on the persistent volume.
+type FileBackend struct {
+	Path string
+}
+
+func (b *FileBackend) Load(_ context.Context) ([]byte, error) {
+	data, err := os.ReadFile(b.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// Save writes the snapshot to the temporary file and renames it, so the snapshot is never partially written.
+func (b *FileBackend) Save(_ context.Context, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(b.Path), filepath.Base(b.Path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.Path)
+}
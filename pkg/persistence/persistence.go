@@ -0,0 +1,135 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SaveTimeout is the timeout of the last save on shutdown.
+const SaveTimeout = 10 * time.Second
+
+// ErrSnapshotTooLarge is returned by backends that can't store the snapshot of this size.
+var ErrSnapshotTooLarge = errors.New("snapshot is too large")
+
+var snapshotSize = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: collector.ApplicationPrefix + "snapshot_size_bytes",
+	Help: "Size of the last encoded snapshot of revision histories",
+})
+
+var snapshotSaveErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: collector.ApplicationPrefix + "snapshot_save_errors_total",
+	Help: "Number of failed snapshot saves by reason: too_large or error",
+}, []string{"reason"})
+
+// RegisterMetrics registers self metrics of persisters.
+func RegisterMetrics(registerer prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{snapshotSize, snapshotSaveErrors} {
+		if err := registerer.Register(c); err != nil {
+			return fmt.Errorf("self metrics registration: %v", err)
+		}
+	}
+	return nil
+}
+
+// Backend stores the snapshot of revision histories.
+type Backend interface {
+	// Load returns the saved snapshot or nil if nothing is saved yet.
+	Load(ctx context.Context) ([]byte, error)
+	Save(ctx context.Context, data []byte) error
+}
+
+// Persister restores revision histories of the vault on startup and saves them when they are changed.
+// Changes are debounced, so the snapshot is saved at most once per debounce interval.
+type Persister struct {
+	backend  Backend
+	vault    *collector.MetricsVault
+	debounce time.Duration
+}
+
+func NewPersister(backend Backend, vault *collector.MetricsVault, debounce time.Duration) *Persister {
+	return &Persister{backend: backend, vault: vault, debounce: debounce}
+}
+
+// Restore loads the saved snapshot to the vault. Broken snapshots are skipped, so the exporter
+// starts with the empty history instead of failing.
+func (p *Persister) Restore(ctx context.Context) error {
+	data, err := p.backend.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		log.Println("snapshot: nothing to restore")
+		return nil
+	}
+	if err := p.vault.Restore(data); err != nil {
+		log.Printf("snapshot: %v, starting with empty history", err)
+	}
+	return nil
+}
+
+// Start saves the snapshot after changes in the background until the context is done, then saves it the last time.
+// The returned channel is closed after the last save, so the exporter can wait for it before exiting.
+func (p *Persister) Start(ctx context.Context) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(ctx)
+	}()
+	return done
+}
+
+func (p *Persister) run(ctx context.Context) {
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			saveCtx, cancel := context.WithTimeout(context.Background(), SaveTimeout)
+			p.save(saveCtx)
+			cancel()
+			return
+		case <-p.vault.Changes():
+			if timer == nil {
+				timer = time.After(p.debounce)
+			}
+		case <-timer:
+			timer = nil
+			p.save(ctx)
+		}
+	}
+}
+
+func (p *Persister) save(ctx context.Context) {
+	data, err := p.vault.Snapshot()
+	if err != nil {
+		log.Printf("snapshot: %v", err)
+		return
+	}
+	snapshotSize.Set(float64(len(data)))
+	if err := p.backend.Save(ctx, data); err != nil {
+		reason := "error"
+		if errors.Is(err, ErrSnapshotTooLarge) {
+			reason = "too_large"
+		}
+		snapshotSaveErrors.WithLabelValues(reason).Inc()
+		log.Printf("snapshot: save: %v", err)
+	}
+}
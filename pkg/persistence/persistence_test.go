@@ -0,0 +1,233 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package persistence
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// memoryBackend records saved snapshots.
+type memoryBackend struct {
+	mu    sync.Mutex
+	saves [][]byte
+	err   error
+}
+
+func (b *memoryBackend) Load(_ context.Context) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.saves) == 0 {
+		return nil, nil
+	}
+	return b.saves[len(b.saves)-1], nil
+}
+
+func (b *memoryBackend) Save(_ context.Context, data []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.saves = append(b.saves, data)
+	return b.err
+}
+
+func (b *memoryBackend) saved() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.saves)
+}
+
+func testVault(t *testing.T) *collector.MetricsVault {
+	t.Helper()
+	vault := collector.NewVault(prometheus.NewRegistry())
+	if err := vault.RegisterMappings([]collector.Mapping{{
+		Name:             "test_persistence",
+		KubeResourceMeta: []string{"name"},
+		KubeAnnotations:  []string{"version"},
+		MaxRevisions:     2,
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	return vault
+}
+
+func testStore(vault *collector.MetricsVault, version string) {
+	vault.Store("test_persistence", collector.Sample{
+		ResourceAnnotations: map[string]string{"version": version},
+		ResourceMeta:        []string{"a"},
+		UID:                 "uid-a",
+	})
+}
+
+func TestFileBackend(t *testing.T) {
+	dir := t.TempDir()
+	backend := &FileBackend{Path: filepath.Join(dir, "snapshot.json")}
+
+	data, err := backend.Load(context.Background())
+	if err != nil || data != nil {
+		t.Fatalf("expected nothing to load, got %q and %v", data, err)
+	}
+	for _, snapshot := range []string{`{"version":1}`, `{"version":1,"metrics":{}}`} {
+		if err := backend.Save(context.Background(), []byte(snapshot)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := backend.Load(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != snapshot {
+			t.Errorf("expected %q, got %q", snapshot, data)
+		}
+	}
+
+	// Temporary files are renamed or removed, so only the snapshot is left.
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "snapshot.json" {
+		t.Errorf("expected only the snapshot file, got %v", entries)
+	}
+}
+
+func TestFileBackendKeepsSnapshotOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	if err := (&FileBackend{Path: path}).Save(context.Background(), []byte(`{"version":1}`)); err != nil {
+		t.Fatal(err)
+	}
+	// The temporary file can't be created in the missing directory.
+	missing := &FileBackend{Path: filepath.Join(path+".d", "snapshot.json")}
+	if err := missing.Save(context.Background(), []byte(`{}`)); err == nil {
+		t.Error("expected error of the missing directory")
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != `{"version":1}` {
+		t.Errorf("expected the snapshot to be kept, got %q and %v", data, err)
+	}
+}
+
+func testConfigMapBackend() *ConfigMapBackend {
+	client := dynamicfake.NewSimpleDynamicClient(runtime.NewScheme())
+	return &ConfigMapBackend{client: client.Resource(configMapsResource).Namespace("default"), namespace: "default", name: "snapshot"}
+}
+
+func TestConfigMapBackend(t *testing.T) {
+	ctx := context.Background()
+	backend := testConfigMapBackend()
+
+	data, err := backend.Load(ctx)
+	if err != nil || data != nil {
+		t.Fatalf("expected nothing to load, got %q and %v", data, err)
+	}
+	// The first save creates the ConfigMap, the next ones update it.
+	for _, snapshot := range []string{`{"version":1}`, `{"version":1,"metrics":{}}`} {
+		if err := backend.Save(ctx, []byte(snapshot)); err != nil {
+			t.Fatal(err)
+		}
+		data, err := backend.Load(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != snapshot {
+			t.Errorf("expected %q, got %q", snapshot, data)
+		}
+	}
+}
+
+func TestConfigMapBackendTooLarge(t *testing.T) {
+	ctx := context.Background()
+	backend := testConfigMapBackend()
+
+	err := backend.Save(ctx, bytes.Repeat([]byte("a"), configMapMaxDataSize+1))
+	if !errors.Is(err, ErrSnapshotTooLarge) {
+		t.Fatalf("expected too large error, got %v", err)
+	}
+	if _, err := backend.client.Get(ctx, backend.name, metav1.GetOptions{}); err == nil {
+		t.Error("expected the ConfigMap not to be created")
+	}
+
+	// The persister counts the failed save by the reason.
+	before := testutil.ToFloat64(snapshotSaveErrors.WithLabelValues("too_large"))
+	NewPersister(&memoryBackend{err: err}, testVault(t), time.Second).save(ctx)
+	if count := testutil.ToFloat64(snapshotSaveErrors.WithLabelValues("too_large")) - before; count != 1 {
+		t.Errorf("expected 1 too large save error, got %v", count)
+	}
+}
+
+func TestPersisterRestore(t *testing.T) {
+	vault := testVault(t)
+	testStore(vault, "1")
+	data, err := vault.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored := testVault(t)
+	if err := NewPersister(&memoryBackend{saves: [][]byte{data}}, restored, time.Second).Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if snapshot, err := restored.Snapshot(); err != nil || !bytes.Equal(snapshot, data) {
+		t.Errorf("expected the restored snapshot %s, got %s and %v", data, snapshot, err)
+	}
+
+	// Broken snapshots are skipped, so the exporter starts with the empty history.
+	broken := &memoryBackend{saves: [][]byte{[]byte(`{"version":`)}}
+	if err := NewPersister(broken, testVault(t), time.Second).Restore(context.Background()); err != nil {
+		t.Errorf("expected broken snapshot to be skipped, got %v", err)
+	}
+}
+
+func TestPersisterDebounce(t *testing.T) {
+	vault := testVault(t)
+	backend := &memoryBackend{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := NewPersister(backend, vault, 100*time.Millisecond).Start(ctx)
+
+	for _, version := range []string{"1", "2", "3"} {
+		testStore(vault, version)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for backend.saved() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if saved := backend.saved(); saved != 1 {
+		t.Fatalf("expected changes to be saved once, got %d saves", saved)
+	}
+
+	// The snapshot is saved the last time on shutdown.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("persister is not stopped")
+	}
+	if saved := backend.saved(); saved != 2 {
+		t.Errorf("expected the final save, got %d saves", saved)
+	}
+	if data, _ := backend.Load(context.Background()); !strings.Contains(string(data), `"3"`) {
+		t.Errorf("expected the last version in the snapshot, got %s", data)
+	}
+}
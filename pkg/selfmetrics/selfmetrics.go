@@ -0,0 +1,60 @@
+// Copyright 2022.
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package selfmetrics contains the registry of metrics about the exporter itself. They are exposed separately
+// from metrics exported from kubernetes objects, so both can be scraped independently.
+package selfmetrics
+
+import (
+	"runtime"
+	"runtime/debug"
+
+	"github.com/alex123012/annotations-exporter/pkg/collector"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// NewRegistry returns the registry of self metrics with go runtime, process and build information metrics.
+// If the commit is empty, the VCS revision embedded by the go toolchain is used.
+func NewRegistry(version, commit string) *prometheus.Registry {
+	if commit == "" {
+		commit = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" {
+					commit = setting.Value
+				}
+			}
+		}
+	}
+	buildInfo := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: collector.ApplicationPrefix + "build_info",
+		Help: "Build information of the exporter, always 1",
+	}, []string{"version", "commit", "goversion"})
+	buildInfo.WithLabelValues(version, commit, runtime.Version()).Set(1)
+
+	registry := NewRuntimeRegistry()
+	registry.MustRegister(buildInfo)
+	return registry
+}
+
+// NewRuntimeRegistry returns the registry of go runtime and process metrics. They are exposed on /metrics too,
+// so scrapes of /metrics alone still see the exporter process.
+func NewRuntimeRegistry() *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
@@ -15,21 +15,56 @@ package server
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
 	"log"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func StartMetricsServer(ctx context.Context, address string, errorCh chan error) {
+// ReadinessChecker reports components of the exporter that are not ready.
+type ReadinessChecker interface {
+	// Unready returns descriptions of components that are not ready, the exporter is ready if there are none.
+	Unready() []string
+}
+
+// StartMetricsServer serves metrics exported from kubernetes objects with go runtime and process metrics on /metrics,
+// and self metrics of the exporter on /self-metrics.
+func StartMetricsServer(ctx context.Context, address string, metrics, selfMetrics prometheus.Gatherer, readiness ReadinessChecker,
+	errorCh chan error) {
 	mux := http.NewServeMux()
 
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics, promhttp.HandlerOpts{}))
+	mux.Handle("/self-metrics", promhttp.HandlerFor(selfMetrics, promhttp.HandlerOpts{}))
 
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+	live := func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		_, _ = w.Write([]byte("ok"))
+	}
+	mux.HandleFunc("/livez", live)
+	// healthz is kept for compatibility, it is the same as livez.
+	mux.HandleFunc("/healthz", live)
+
+	// readyz lists components that are not ready with the verbose query parameter.
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		unready := readiness.Unready()
+		_, verbose := r.URL.Query()["verbose"]
+		if len(unready) == 0 {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte("ok"))
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+		if !verbose {
+			_, _ = w.Write([]byte("not ready"))
+			return
+		}
+		_, _ = w.Write([]byte("not ready:\n"))
+		for _, component := range unready {
+			_, _ = fmt.Fprintf(w, "- %s\n", component)
+		}
 	})
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -37,7 +72,9 @@ func StartMetricsServer(ctx context.Context, address string, errorCh chan error)
 		_, _ = w.Write([]byte(`<!DOCTYPE html>
 			<title>Annotations Exporter</title>
 			<h1>Annotations Exporter</h1>
-			<p><a href=/metrics>Metrics</a></p>`))
+			<p><a href=/metrics>Metrics</a></p>
+			<p><a href=/self-metrics>Self metrics</a></p>
+			<p><a href=/readyz?verbose>Readiness</a></p>`))
 	})
 
 	log.Printf("start exporting metrics on %q", address)